@@ -0,0 +1,68 @@
+package scache
+
+import (
+	"fmt"
+	"time"
+)
+
+// autoResizeStep is the largest fraction of the capacity StartAutoResize changes it by per
+// interval, so a few noisy intervals cannot swing the capacity from one bound to the other.
+const autoResizeStep = 10
+
+// StartAutoResize starts a background goroutine that adjusts the capacity of the cache every
+// interval to keep the hit ratio of the lookups during the interval near targetHitRatio. The
+// capacity grows while the hit ratio is below the target and shrinks, releasing the least
+// recently used entries, while it is saturated, i.e. at least halfway between the target and
+// 1. Each adjustment changes the capacity by at most a tenth, and it always stays between
+// minCap and maxCap. Intervals without lookups leave the capacity alone. The goroutine stops
+// when the cache is closed.
+//
+// StartAutoResize panics if minCap is not positive, maxCap is below minCap or targetHitRatio
+// is not in (0, 1].
+func (c *Cache) StartAutoResize(minCap, maxCap int, targetHitRatio float64, interval time.Duration) {
+	if minCap <= 0 || maxCap < minCap {
+		panic(fmt.Sprintf("scache: invalid auto resize bounds [%d, %d]", minCap, maxCap))
+	}
+	if targetHitRatio <= 0 || targetHitRatio > 1 {
+		panic(fmt.Sprintf("scache: target hit ratio must be in (0, 1], got %v", targetHitRatio))
+	}
+
+	ticker := time.NewTicker(interval)
+	hits, misses := c.hits.Load(), c.misses.Load()
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				h, m := c.hits.Load(), c.misses.Load()
+				c.autoResize(minCap, maxCap, targetHitRatio, h-hits, m-misses)
+				hits, misses = h, m
+			case <-c.done:
+				return
+			}
+		}
+	}()
+}
+
+// autoResize applies one StartAutoResize adjustment given the hits and misses observed since
+// the previous one.
+func (c *Cache) autoResize(minCap, maxCap int, target float64, hits, misses int64) {
+	c.lock()
+	defer c.unlock()
+	if c.closed.Load() || c.frozen.Load() {
+		return
+	}
+
+	capacity := min(max(c.capacity, minCap), maxCap)
+	if lookups := hits + misses; lookups > 0 {
+		step := max(capacity/autoResizeStep, 1)
+		switch ratio := float64(hits) / float64(lookups); {
+		case ratio < target:
+			capacity = min(capacity+step, maxCap)
+		case ratio >= (1+target)/2:
+			capacity = max(capacity-step, minCap)
+		}
+	}
+	c.capacity = capacity
+	c.evictLRU(len(c.items) - capacity)
+}
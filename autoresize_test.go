@@ -0,0 +1,66 @@
+package scache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCacheAutoResize(t *testing.T) {
+	cache := New(100)
+	for i := range 100 {
+		if err := cache.Set(strconv.Itoa(i), testValue, 0); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+
+	// Too many misses: grow by a bounded step, up to the maximum.
+	cache.autoResize(50, 115, 0.9, 50, 50)
+	if _, capacity := cache.Usage(); capacity != 110 {
+		t.Errorf("capacity = %v, want %v", capacity, 110)
+	}
+	cache.autoResize(50, 115, 0.9, 50, 50)
+	if _, capacity := cache.Usage(); capacity != 115 {
+		t.Errorf("capacity = %v, want %v", capacity, 115)
+	}
+
+	// Between the target and saturation, or without lookups: keep the capacity.
+	cache.autoResize(50, 115, 0.9, 92, 8)
+	cache.autoResize(50, 115, 0.9, 0, 0)
+	if _, capacity := cache.Usage(); capacity != 115 {
+		t.Errorf("capacity = %v, want %v", capacity, 115)
+	}
+
+	// Saturated: shrink, evicting the least recently used entries.
+	for range 10 {
+		cache.autoResize(95, 115, 0.9, 99, 1)
+	}
+	if size, capacity := cache.Usage(); size != 95 || capacity != 95 {
+		t.Errorf("Usage() = %v, %v, want %v, %v", size, capacity, 95, 95)
+	}
+	if cache.Contains("0") || !cache.Contains("99") {
+		t.Errorf("Keys() = %v, want the 95 most recently used", cache.Keys())
+	}
+}
+
+func TestCacheStartAutoResize(t *testing.T) {
+	cache := New(10)
+	cache.StartAutoResize(10, 20, 0.9, 5*time.Millisecond)
+	for range 10 {
+		_, _ = cache.Get("missing")
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, capacity := cache.Usage(); capacity > 10 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("capacity did not grow")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if s := cache.Stats(); s.Misses != 10 || s.HitRatio() != 0 {
+		t.Errorf("Stats() = %+v, want %v misses", s, 10)
+	}
+	cache.Close()
+}
@@ -1,128 +1,2639 @@
 // Package scache providers a cache functionality that stores key/value pairs.
+//
+// Values are stored as strings. Go strings are immutable, so a value returned by Get can
+// never be used to modify the cached copy and no defensive copying takes place on Set or Get.
 package scache
 
 import (
-	"container/list"
+	"context"
 	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"path"
+	"runtime"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Errors returned by the cache.
+var (
+	// ErrNotFound is returned when a key is missing or its entry has expired.
+	ErrNotFound = errors.New("key not found")
+	// ErrClosed is returned when the cache has been closed.
+	ErrClosed = errors.New("cache is closed")
+	// ErrInvalidTTL is returned when the TTL is negative.
+	ErrInvalidTTL = errors.New("invalid ttl")
+	// ErrValueTooLarge is returned when the value exceeds the configured maximum size.
+	ErrValueTooLarge = errors.New("value too large")
+	// ErrCacheFull is returned when the cache is at capacity and configured to reject new keys.
+	ErrCacheFull = errors.New("cache is full")
+	// ErrQueueFull is returned when the asynchronous write queue has no room left.
+	ErrQueueFull = errors.New("write queue is full")
+	// ErrFrozen is returned by writes to a cache that has been frozen with Freeze.
+	ErrFrozen = errors.New("cache is frozen")
+)
+
+// ErrLoaderPanic is returned by GetOrSet and GetOrLoad when the loader panicked.
+var ErrLoaderPanic = errors.New("loader panicked")
+
+// Logger is the interface used by the cache to report unexpected conditions.
+// It is satisfied by *log.Logger.
+type Logger interface {
+	Printf(format string, v ...any)
+}
+
+// Spiller persists entries evicted to make room, e.g. to disk, and restores them on a miss.
+// See WithSpiller.
+type Spiller interface {
+	// Spill stores an entry evicted from the cache. A zero expiry means it never expires.
+	Spill(key, value string, expiry time.Time) error
+	// Restore returns an entry stored by Spill and reports whether it was found.
+	Restore(key string) (value string, expiry time.Time, ok bool)
+}
+
 // CacheItem stores the value and the expiry time of a cache entry.
+// A zero ExpiryTime means the entry never expires.
+//
+// Version starts at 1 when a key is created and increments on every update of the key. It
+// starts over once the key has been deleted, evicted or has expired.
 type CacheItem struct {
 	Value      string
 	ExpiryTime time.Time
+	Version    uint64
+}
+
+// CacheItemWithKey is a cache item together with the key it is stored under.
+type CacheItemWithKey struct {
+	Key string
+	CacheItem
+}
+
+// KV is a key together with its value.
+type KV struct {
+	Key   string
+	Value string
+}
+
+// Entry is a value together with its TTL, see SetMulti.
+type Entry struct {
+	Value string
+	TTL   time.Duration
+}
+
+// expiryTime returns the expiry time of an entry stored at now with the given TTL. A zero
+// TTL, or one so large that the addition would overflow, yields the zero time so the entry
+// never expires.
+func expiryTime(now time.Time, ttl time.Duration) time.Time {
+	at := now.Add(ttl)
+	if ttl == 0 || !at.After(now) {
+		return time.Time{}
+	}
+	return at
+}
+
+// expired reports whether the item has expired at the given time.
+func (i CacheItem) expired(now time.Time) bool {
+	return !i.ExpiryTime.IsZero() && now.After(i.ExpiryTime)
+}
+
+// expired reports whether item has expired at the given time, unless expiry is paused.
+func (c *Cache) expired(item CacheItem, now time.Time) bool {
+	return !c.expiryPaused.Load() && item.expired(now)
+}
+
+// expiresAfter reports whether the item expires later than other.
+func (i CacheItem) expiresAfter(other CacheItem) bool {
+	if i.ExpiryTime.IsZero() || other.ExpiryTime.IsZero() {
+		return i.ExpiryTime.IsZero() && !other.ExpiryTime.IsZero()
+	}
+	return i.ExpiryTime.After(other.ExpiryTime)
 }
 
 // entry is a helper struct that stores a cache item along with its key.
 type entry struct {
-	key   string
-	value CacheItem
+	key      string
+	value    CacheItem
+	onExpire func(key, value string) // Per-entry callback, see SetWithCallback
+	accesses int                     // Accesses since the last promotion, see WithPromotionThreshold
+
+	created     time.Time // When the key was first stored
+	lastAccess  time.Time // When the entry was last returned by a lookup
+	accessCount uint64    // Number of lookups that returned the entry, saturating at math.MaxUint64
+	modified    time.Time // When the entry was last stored or its expiry changed, see ChangesSince
+
+	meta   map[string]string // User metadata, see SetWithMeta
+	delta  time.Duration     // How long the GetOrLoad loader took, see WithEarlyExpiration
+	pins   int               // Unreleased GetRef references, which protect it from eviction
+	weight float64           // Protection from sampled eviction, see SetWithWeight
+
+	prev, next *entry // Links of the intrusive eviction list, see WithIntrusiveList
+}
+
+// size returns the number of bytes of the key, value and metadata of the entry.
+func (kv *entry) size() int {
+	n := len(kv.key) + len(kv.value.Value)
+	for k, v := range kv.meta {
+		n += len(k) + len(v)
+	}
+	return n
+}
+
+// EvictReason describes why an entry was removed from the cache.
+type EvictReason int
+
+const (
+	// Expired means the entry outlived its TTL.
+	Expired EvictReason = iota
+	// Evicted means the entry was removed to make room for another one.
+	Evicted
+	// Deleted means the entry was removed explicitly.
+	Deleted
+	// Drained means the entry was removed by Drain.
+	Drained
+)
+
+// evicted is an entry removed from the cache whose callbacks have not run yet.
+type evicted struct {
+	entry  *entry
+	reason EvictReason
 }
 
+// noCopy may be embedded into structs which must not be copied after first use.
+// It is detected by the copylocks checker of go vet.
+type noCopy struct{}
+
+// Lock is a no-op used by the copylocks checker.
+func (*noCopy) Lock() {}
+
+// Unlock is a no-op used by the copylocks checker.
+func (*noCopy) Unlock() {}
+
 // Cache represents a thread-safe in-memory cache with TTL and LRU eviction policies.
+//
+// When the cache is full, the least recently used entry is evicted. An entry is used when it
+// is stored, by Set or any other write including overwrites, and when a lookup that promotes
+// returns it, such as Get. Peek, Contains, Keys and the iteration methods do not count as
+// use. Entries that have not been used since they were stored are therefore evicted in
+// insertion order. The order does not depend on expiry times: an expired entry that has not
+// been removed yet is evicted only when it is the least recently used one. There are three
+// exceptions: with WithPromotionThreshold the order is only approximately LRU, with
+// WithWeightedSampledEviction the victim is picked at random among the least recently used
+// entries, and entries pinned by GetRef are skipped until they are released.
+//
+// Callbacks such as those configured with WithOnEvict and WithOnMiss never run while the
+// cache lock is held. A panic in a callback is recovered and logged with the logger configured
+// with WithLogger, so a faulty callback cannot crash the process or leave the cache locked.
+//
+// A Cache must not be copied after first use; always pass it around as the *Cache returned
+// by New. Copies are reported by go vet.
 type Cache struct {
-	mu       sync.RWMutex
-	items    map[string]*list.Element // Map of keys to list elements
-	eviction *list.List               // Doubly-linked list for eviction
-	capacity int                      // Maximum number of items in the cache
+	noCopy noCopy
+
+	mu           sync.RWMutex
+	items        map[string]listNode                         // Map of keys to eviction list nodes
+	eviction     evictionList                                // Recency order for eviction, see WithIntrusiveList
+	intrusive    bool                                        // Whether to use an intrusive eviction list, see WithIntrusiveList
+	capacity     int                                         // Maximum number of items in the cache
+	maxValueSize int                                         // Maximum value length in bytes, 0 means unlimited
+	rejectOnFull bool                                        // Reject new keys instead of evicting when full
+	closed       atomic.Bool                                 // Set by Close, read without the lock by validate
+	done         chan struct{}                               // Closed by Close to stop background goroutines
+	onEvict      func(key, value string, reason EvictReason) // Global eviction callback
+	pending      []evicted                                   // Removed entries awaiting callbacks
+	writes       chan *entry                                 // Asynchronous write queue, see WithAsyncWrites
+	writerDone   chan struct{}                               // Closed once the queue has been flushed after Close
+	normalizer   func(string) string                         // Applied to every key, nil means identity
+	promoteAfter int                                         // Accesses needed before an entry is promoted
+	evictBatch   int                                         // Entries checked for expiry when making room
+	valueIndex   map[string]string                           // Reverse index from value to key, see WithValueIndex
+	logger       Logger                                      // Optional logger for unexpected conditions
+	clock        func() time.Time                            // Source of the current time, see WithClock
+	highWater    *highWater                                  // Capacity pressure warning, see WithHighWaterMark
+	sweepBudget  int                                         // Entries examined per sweep, 0 means all
+	sweepCursor  listNode                                    // Where the next incremental sweep resumes
+	lockMetrics  *lockMetrics                                // Lock wait statistics, see WithLockMetrics
+	shardHasher  func(key string) uint64                     // Shard selection for NewSharded, see WithShardHasher
+	onMiss       func(key string)                            // Called when Get or Contains misses, see WithOnMiss
+	tombstones   map[string]time.Time                        // Deletion times of deleted keys, see WithTombstones
+	tombstoneTTL time.Duration                               // How long tombstones are retained
+	maxTTL       time.Duration                               // Upper bound for every TTL, see WithMaxTTL
+	waiters      map[string][]chan struct{}                  // GetOrWait calls by key, closed when the key is stored
+	fallback     *Cache                                      // Consulted by Get on a miss, see WithFallback
+	expiryLag    durationStats                               // Time between expiry and removal by a sweep
+	spiller      Spiller                                     // Persists evicted entries, see WithSpiller
+	earlyBeta    float64                                     // Early expiration factor, see WithEarlyExpiration
+	random       func() float64                              // Source of randomness in [0, 1)
+	topK         *topK                                       // Most frequently hit keys, see WithTopK
+	evictSample  int                                         // Eviction candidates, see WithWeightedSampledEviction
+	equalFunc    func(a, b string) bool                      // Value equality for CompareAndSwap, nil means ==
+	onEmpty      func(empty bool)                            // Emptiness transition hook, see WithEmptyTransitionHook
+	isEmpty      atomic.Bool                                 // Whether the cache was empty when the lock was last released
+	protected    map[string]struct{}                         // Keys exempt from Flush, see Protect
+	loadSlots    chan struct{}                               // Semaphore for loader calls, see WithMaxConcurrentLoads
+	hitWindow    *hitWindow                                  // Recent hits and misses, see WithHitRatioWindow
+	expiryPaused atomic.Bool                                 // Set between PauseExpiry and ResumeExpiry
+	hits         atomic.Int64                                // Lookups served from the cache, see Stats
+	misses       atomic.Int64                                // Get calls that found no live entry
+	listOps      listOps                                     // Eviction list mutations, see Stats
+	frozen       atomic.Bool                                 // Set by Freeze, read without the lock by Get
+
+	callsMu sync.Mutex
+	calls   map[string]*call // In-flight GetOrSet loads by key
+
+	emptyMu        sync.Mutex
+	emptyReported  bool // Last state passed to onEmpty
+	emptyReporting bool // Whether a goroutine is running onEmpty
 }
 
-// New initializes and returns a new Cache with the given capacity.
-func New(capacity int) *Cache {
-	return &Cache{
-		items:    make(map[string]*list.Element),
-		eviction: list.New(),
+// highWater tracks the state of the callback configured with WithHighWaterMark.
+type highWater struct {
+	fn       func(size, capacity int)
+	fraction float64 // Fraction of the capacity at which fn is called
+	armed    bool    // Whether crossing the mark calls fn
+}
+
+// check updates the state of h for the given size and capacity and reports whether fn is due.
+// The mark is derived from the current capacity, so it follows capacity changes.
+func (h *highWater) check(size, capacity int) bool {
+	mark := max(int(math.Ceil(h.fraction*float64(capacity))), 1)
+	rearm := min(mark*9/10, mark-1)
+	switch {
+	case h.armed && size >= mark:
+		h.armed = false
+		return true
+	case !h.armed && size <= rearm:
+		h.armed = true
+	}
+	return false
+}
+
+// call is an in-flight GetOrSet load shared by every caller asking for the same key.
+type call struct {
+	done  chan struct{} // Closed when the load completes, releasing all waiters at once
+	value string
+	err   error
+}
+
+// New initializes and returns a new Cache with the given capacity and options.
+func New(capacity int, opts ...Option) *Cache {
+	c := &Cache{
+		items:    make(map[string]listNode),
 		capacity: capacity,
+		done:     make(chan struct{}),
+		calls:    make(map[string]*call),
+		clock:    time.Now,
+		random:   rand.Float64,
+	}
+	for _, opt := range opts {
+		opt(c)
 	}
+	c.eviction = c.newEvictionList()
+	if c.writes != nil {
+		c.writerDone = make(chan struct{})
+		go c.runWriter()
+	}
+	return c
 }
 
 // Set adds or updates a cache entry with the specified key, value, and TTL.
+// A zero ttl means the entry never expires.
+// Overwriting a key does not trigger eviction callbacks for the old value.
+//
+// Set returns ErrClosed if the cache has been closed, ErrInvalidTTL if ttl is negative,
+// ErrValueTooLarge if the value exceeds the size configured with WithMaxValueSize, ErrFrozen
+// if the cache has been frozen and ErrCacheFull if the key is new, the cache is at capacity
+// and WithRejectOnFull is set. The errors are checked in that order and the cache is left
+// unchanged when one is returned.
+//
+// With WithAsyncWrites, Set returns ErrQueueFull instead of blocking when the write queue has
+// no room, and ErrCacheFull is never returned because the entry is stored after Set returns.
 func (c *Cache) Set(key, value string, ttl time.Duration) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	return c.set(key, value, ttl, c.now(), nil)
+}
 
-	// Remove the old value if it exists
-	if elem, found := c.items[key]; found {
-		c.eviction.Remove(elem)
-		delete(c.items, key)
+// SetAt is like Set but computes the expiry time from the given time instead of the cache
+// clock. It allows driving the cache through a recorded timeline, e.g. in replay tests.
+func (c *Cache) SetAt(key, value string, ttl time.Duration, now time.Time) error {
+	return c.set(key, value, ttl, now, nil)
+}
+
+// SetWithCallback adds or updates a cache entry like Set and registers onExpire to be called
+// when the entry expires or is evicted to make room for another one. The callback runs in
+// addition to the global callback configured with WithOnEvict. It is discarded without being
+// called if the key is overwritten, deleted or drained first.
+func (c *Cache) SetWithCallback(key, value string, ttl time.Duration, onExpire func(key, value string)) error {
+	return c.set(key, value, ttl, c.now(), onExpire)
+}
+
+// SetWithMeta adds or updates a cache entry like Set and stores a copy of meta alongside the
+// value, e.g. its source or content type. Overwriting the key with Set drops the metadata.
+func (c *Cache) SetWithMeta(key, value string, meta map[string]string, ttl time.Duration) error {
+	if err := c.validate(value, ttl); err != nil {
+		return err
 	}
 
-	// Evict the least recently used item if the cache is at capacity
-	if c.eviction.Len() >= c.capacity {
-		c.evictLRU()
+	item := CacheItem{
+		Value:      value,
+		ExpiryTime: expiryTime(c.now(), ttl),
+	}
+	return c.put(&entry{key: c.normalize(key), value: item, meta: maps.Clone(meta)})
+}
+
+// SetWithWeight adds or updates a cache entry like Set and gives it a weight for the eviction
+// configured with WithWeightedSampledEviction: every unit of weight halves the chance of the
+// entry being picked for eviction among the sampled candidates, so entries that are expensive
+// to rebuild can be kept resident. Entries stored otherwise have weight 0, and overwriting the
+// key with Set resets it. Without WithWeightedSampledEviction the weight has no effect.
+func (c *Cache) SetWithWeight(key, value string, weight float64, ttl time.Duration) error {
+	if err := c.validate(value, ttl); err != nil {
+		return err
 	}
 
 	item := CacheItem{
 		Value:      value,
-		ExpiryTime: time.Now().Add(ttl),
+		ExpiryTime: expiryTime(c.now(), ttl),
 	}
-	elem := c.eviction.PushFront(&entry{key, item})
-	c.items[key] = elem
+	return c.put(&entry{key: c.normalize(key), value: item, weight: weight})
+}
 
-	return nil
+// SetWithDeadline adds or updates a cache entry that expires at deadline. Computing the
+// deadline once for a batch of entries gives them a uniform expiry and saves a clock read per
+// entry. A zero deadline means the entry never expires. It returns the same errors as Set,
+// except ErrInvalidTTL.
+func (c *Cache) SetWithDeadline(key, value string, deadline time.Time) error {
+	if err := c.validate(value, 0); err != nil {
+		return err
+	}
+	return c.put(&entry{key: c.normalize(key), value: CacheItem{Value: value, ExpiryTime: deadline}})
 }
 
-// Get retrieves a cache entry by its key. It returns the value and a boolean indicating whether the key was found.
-func (c *Cache) Get(key string) (string, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	elem, found := c.items[key]
-	if !found || time.Now().After(elem.Value.(*entry).value.ExpiryTime) {
-		// If the item is not found or has expired, return false
+// SetUntilCtx adds or updates a cache entry that expires at the deadline of ctx, tying the
+// lifetime of the entry to a request. A context without deadline stores an entry that never
+// expires. If ctx is already done, SetUntilCtx returns ctx.Err() without storing anything.
+// Otherwise it returns the same errors as Set.
+func (c *Cache) SetUntilCtx(ctx context.Context, key, value string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	return c.SetWithDeadline(key, value, deadline)
+}
+
+// set implements Set, SetAt and SetWithCallback.
+func (c *Cache) set(key, value string, ttl time.Duration, now time.Time, onExpire func(key, value string)) error {
+	if err := c.validate(value, ttl); err != nil {
+		return err
+	}
+
+	item := CacheItem{
+		Value:      value,
+		ExpiryTime: expiryTime(now, ttl),
+	}
+	return c.put(&entry{key: c.normalize(key), value: item, onExpire: onExpire})
+}
+
+// SetWithVersion stores value under key only if the current version of the key equals
+// expectedVersion, where an absent or expired key has version 0. It reports whether the value
+// was stored, and returns the same errors as Set.
+func (c *Cache) SetWithVersion(key, value string, expectedVersion uint64, ttl time.Duration) (bool, error) {
+	if err := c.validate(value, ttl); err != nil {
+		return false, err
+	}
+	key = c.normalize(key)
+
+	c.lock()
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return false, ErrClosed
+	}
+	now := c.now()
+	var current uint64
+	if _, kv, found := c.lookup(key); found && !c.expired(kv.value, now) {
+		current = kv.value.Version
+	}
+	if current != expectedVersion {
+		return false, nil
+	}
+
+	item := CacheItem{
+		Value:      value,
+		ExpiryTime: expiryTime(now, ttl),
+	}
+	if err := c.store(&entry{key: key, value: item}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndSwap stores value under key only if the key holds a live entry whose value equals
+// old, as decided by the function configured with WithEqualFunc or by == otherwise. It reports
+// whether the value was stored, and returns the same errors as Set. Like SetWithVersion,
+// CompareAndSwap is applied directly even with WithAsyncWrites.
+func (c *Cache) CompareAndSwap(key, old, value string, ttl time.Duration) (bool, error) {
+	if err := c.validate(value, ttl); err != nil {
+		return false, err
+	}
+	key = c.normalize(key)
+
+	c.lock()
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return false, ErrClosed
+	}
+	now := c.now()
+	_, kv, found := c.lookup(key)
+	if !found || c.expired(kv.value, now) || !c.equal(kv.value.Value, old) {
+		return false, nil
+	}
+
+	item := CacheItem{
+		Value:      value,
+		ExpiryTime: expiryTime(now, ttl),
+	}
+	if err := c.store(&entry{key: key, value: item}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// equal reports whether two values are equal for CompareAndSwap.
+func (c *Cache) equal(a, b string) bool {
+	if c.equalFunc != nil {
+		return c.equalFunc(a, b)
+	}
+	return a == b
+}
+
+// Replace stores value under key like Set and returns the value it replaced. existed is false
+// if the key was absent or expired. It returns the same errors as Set, in which case the cache
+// is left unchanged. Like SetWithVersion, Replace is applied directly even with WithAsyncWrites.
+func (c *Cache) Replace(key, value string, ttl time.Duration) (old string, existed bool, err error) {
+	if err := c.validate(value, ttl); err != nil {
+		return "", false, err
+	}
+	key = c.normalize(key)
+
+	c.lock()
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return "", false, ErrClosed
+	}
+	now := c.now()
+	if _, kv, found := c.lookup(key); found && !c.expired(kv.value, now) {
+		old, existed = kv.value.Value, true
+	}
+
+	item := CacheItem{
+		Value:      value,
+		ExpiryTime: expiryTime(now, ttl),
+	}
+	if err := c.store(&entry{key: key, value: item}); err != nil {
+		return "", false, err
+	}
+	return old, existed, nil
+}
+
+// Update atomically reads, modifies and writes the entry stored for key. It calls fn with the
+// current value, or with found false if the key is absent or expired, and then stores the
+// value fn returns with the given TTL if keep is true, or deletes the entry if keep is false,
+// calling the global eviction callback with reason Deleted. If fn returns an error, Update
+// returns it and leaves the entry unchanged.
+//
+// fn runs with the cache lock held, so it must be fast and must not call back into the cache,
+// which would deadlock. Update returns the same errors as Set and is applied directly even
+// with WithAsyncWrites.
+func (c *Cache) Update(key string, ttl time.Duration, fn func(old string, found bool) (value string, keep bool, err error)) error {
+	if err := c.validate("", ttl); err != nil {
+		return err
+	}
+	key = c.normalize(key)
+
+	c.lock()
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	if c.frozen.Load() {
+		return ErrFrozen
+	}
+	now := c.now()
+	elem, kv, found := c.lookup(key)
+	found = found && !c.expired(kv.value, now)
+	var old string
+	if found {
+		old = kv.value.Value
+	}
+
+	value, keep, err := fn(old, found)
+	if err != nil {
+		return err
+	}
+	if !keep {
 		if found {
-			c.eviction.Remove(elem)
-			delete(c.items, key)
+			c.remove(elem, Deleted)
 		}
-		return "", errors.New("key not found")
+		return nil
 	}
-	// Move the accessed element to the front of the eviction list
-	c.eviction.MoveToFront(elem)
-	return elem.Value.(*entry).value.Value, nil
+	if err := c.validate(value, ttl); err != nil {
+		return err
+	}
+	item := CacheItem{
+		Value:      value,
+		ExpiryTime: expiryTime(now, ttl),
+	}
+	return c.store(&entry{key: key, value: item})
 }
 
-// Contains checks if cached key exists in the cache.
-func (c *Cache) Contains(key string) bool {
-	_, err := c.Get(key)
-	return err == nil
+// ReplacePrefix atomically replaces every entry whose key starts with prefix by the given
+// items, each stored under prefix followed by its key, so readers never observe a partially
+// updated prefix. It returns the net change in the number of entries. The global eviction
+// callback is called with reason Deleted for the removed entries, and entries are evicted in
+// LRU order as needed to respect the capacity. ReplacePrefix returns the same errors as Set,
+// checking them all before modifying the cache.
+func (c *Cache) ReplacePrefix(prefix string, items map[string]string, ttl time.Duration) (int, error) {
+	for _, value := range items {
+		if err := c.validate(value, ttl); err != nil {
+			return 0, err
+		}
+	}
+	normalized := c.normalize(prefix)
+
+	c.lock()
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return 0, ErrClosed
+	}
+	if c.frozen.Load() {
+		return 0, ErrFrozen
+	}
+	var stale []listNode
+	for key, elem := range c.items {
+		if strings.HasPrefix(key, normalized) {
+			stale = append(stale, elem)
+		}
+	}
+	before := len(c.items)
+	if c.rejectOnFull && before-len(stale)+len(items) > c.capacity {
+		return 0, ErrCacheFull
+	}
+
+	for _, elem := range stale {
+		c.remove(elem, Deleted)
+	}
+	expiry := expiryTime(c.now(), ttl)
+	for key, value := range items {
+		kv := &entry{key: c.normalize(prefix + key), value: CacheItem{Value: value, ExpiryTime: expiry}}
+		if err := c.store(kv); err != nil {
+			return len(c.items) - before, err
+		}
+	}
+	return len(c.items) - before, nil
 }
 
-// Flush removes all cached keys of the cache.
-func (c *Cache) Flush() error {
-	c.items = make(map[string]*list.Element)
-	c.eviction = list.New()
+// validate checks the cache state, value and TTL passed to a write, in the order documented on
+// Set. Writes check for ErrClosed again once they hold the lock, as Close may run in between.
+func (c *Cache) validate(value string, ttl time.Duration) error {
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+	if c.maxValueSize > 0 && len(value) > c.maxValueSize {
+		return ErrValueTooLarge
+	}
 	return nil
 }
 
-// evictLRU removes the least recently used item from the cache.
-func (c *Cache) evictLRU() {
-	elem := c.eviction.Back()
-	if elem != nil {
-		c.eviction.Remove(elem)
-		kv := elem.Value.(*entry)
-		delete(c.items, kv.key)
+// SetItem stores item verbatim under key, including its expiry time, evicting the least
+// recently used entry if the cache is at capacity. A zero Version is replaced by the next
+// version of the key. It returns the same errors as Set.
+func (c *Cache) SetItem(key string, item CacheItem) error {
+	if err := c.validate(item.Value, 0); err != nil {
+		return err
 	}
+	return c.put(&entry{key: c.normalize(key), value: item})
 }
 
-// StartEvictionTicker starts a background goroutine that periodically evicts expired items.
-func (c *Cache) StartEvictionTicker(d time.Duration) {
-	ticker := time.NewTicker(d)
-	go func() {
-		for range ticker.C {
-			c.evictExpiredItems()
+// SetMulti adds or updates the given entries under a single lock acquisition, each with its own
+// TTL, where zero means no expiration as for Set. Entries are evicted in LRU order as needed to
+// respect the capacity; if items holds more entries than fit, which of them remain is
+// unspecified. SetMulti returns the same errors as Set, checking them all before modifying the
+// cache, and stores the entries directly even with WithAsyncWrites.
+func (c *Cache) SetMulti(items map[string]Entry) error {
+	for _, e := range items {
+		if err := c.validate(e.Value, e.TTL); err != nil {
+			return err
 		}
-	}()
+	}
+
+	c.lock()
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	if c.frozen.Load() {
+		return ErrFrozen
+	}
+	if c.rejectOnFull {
+		added := 0
+		for key := range items {
+			if _, found := c.items[c.normalize(key)]; !found {
+				added++
+			}
+		}
+		if len(c.items)+added > c.capacity {
+			return ErrCacheFull
+		}
+	}
+
+	now := c.now()
+	for key, e := range items {
+		kv := &entry{key: c.normalize(key), value: CacheItem{Value: e.Value, ExpiryTime: expiryTime(now, e.TTL)}}
+		if err := c.store(kv); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// evictExpiredItems removes all expired items from the cache.
-func (c *Cache) evictExpiredItems() {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	now := time.Now()
-	for key, elem := range c.items {
-		if now.After(elem.Value.(*entry).value.ExpiryTime) {
-			c.eviction.Remove(elem)
-			delete(c.items, key)
+// put stores a validated entry, either directly or through the asynchronous write queue.
+func (c *Cache) put(kv *entry) error {
+	if c.writes != nil {
+		return c.enqueue(kv)
+	}
+
+	c.lock()
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	return c.store(kv)
+}
+
+// store adds kv to the cache, replacing any entry with the same key and evicting the least
+// recently used item if the cache is at capacity. The caller must hold the lock.
+func (c *Cache) store(kv *entry) error {
+	if c.frozen.Load() {
+		return ErrFrozen
+	}
+	if _, found := c.items[kv.key]; !found && c.rejectOnFull && c.eviction.len() >= c.capacity {
+		return ErrCacheFull
+	}
+	c.insert(kv)
+	return nil
+}
+
+// insert implements store without its checks: it replaces any entry with the same key,
+// keeping the history of the key, clears its tombstone and makes room if needed. The caller
+// must hold the lock.
+func (c *Cache) insert(kv *entry) {
+	// Remove the old value if it exists
+	now := c.now()
+	var version uint64
+	kv.created, kv.modified = now, now
+	delete(c.tombstones, kv.key)
+	if elem, found := c.items[kv.key]; found {
+		if old := c.unlink(elem); old != nil && !c.expired(old.value, now) {
+			// Updates keep the history of the key.
+			version = old.value.Version
+			kv.created, kv.lastAccess, kv.accessCount = old.created, old.lastAccess, old.accessCount
+		}
+	}
+
+	// Evict the least recently used item if the cache is at capacity
+	c.makeRoom()
+
+	if kv.value.Version == 0 {
+		kv.value.Version = version + 1
+	}
+	kv.value.ExpiryTime = c.clampExpiry(kv.key, kv.value.ExpiryTime, now)
+	c.add(kv)
+}
+
+// clampExpiry limits the expiry time at of key to now plus the maximum TTL configured with
+// WithMaxTTL, treating the zero time as later than any limit.
+func (c *Cache) clampExpiry(key string, at, now time.Time) time.Time {
+	if c.maxTTL <= 0 {
+		return at
+	}
+	if limit := now.Add(c.maxTTL); at.IsZero() || at.After(limit) {
+		c.logf("scache: expiry of key %q clamped to the maximum TTL of %v", key, c.maxTTL)
+		return limit
+	}
+	return at
+}
+
+// enqueue hands kv over to the background writer started by WithAsyncWrites.
+func (c *Cache) enqueue(kv *entry) error {
+	// Holding the read lock keeps Close from completing while kv is queued,
+	// so every accepted write is flushed by Close.
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	if c.frozen.Load() {
+		return ErrFrozen
+	}
+	select {
+	case c.writes <- kv:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// runWriter drains the asynchronous write queue into the cache until the cache is closed,
+// then flushes whatever is left in the queue.
+func (c *Cache) runWriter() {
+	defer close(c.writerDone)
+	for {
+		select {
+		case kv := <-c.writes:
+			c.write(kv)
+		case <-c.done:
+			for {
+				select {
+				case kv := <-c.writes:
+					c.write(kv)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write stores a queued entry.
+func (c *Cache) write(kv *entry) {
+	c.lock()
+	defer c.unlock()
+	// There is no caller left to report ErrCacheFull to.
+	_ = c.store(kv)
+}
+
+// normalize applies the key normalizer configured with WithKeyNormalizer.
+func (c *Cache) normalize(key string) string {
+	if c.normalizer == nil {
+		return key
+	}
+	return c.normalizer(key)
+}
+
+// now returns the current time of the cache clock.
+func (c *Cache) now() time.Time {
+	return c.clock()
+}
+
+// add pushes a new entry to the front of the eviction list and wakes up the GetOrWait calls
+// waiting for its key. The caller must hold the lock and ensure the key is not already
+// present.
+func (c *Cache) add(kv *entry) {
+	elem := c.eviction.pushFront(kv)
+	c.listOps.insertions.Add(1)
+	c.items[kv.key] = elem
+	if c.valueIndex != nil {
+		c.valueIndex[kv.value.Value] = kv.key
+	}
+	c.notifyWaiters(kv.key)
+}
+
+// unlink removes elem from the eviction list, the map and the value index without running
+// any callbacks. The caller must hold the lock.
+func (c *Cache) unlink(elem listNode) *entry {
+	if c.sweepCursor == elem {
+		c.sweepCursor = c.eviction.prev(elem)
+	}
+	c.eviction.remove(elem)
+	c.listOps.removals.Add(1)
+	kv, ok := c.entryOf(elem)
+	if !ok {
+		return nil
+	}
+	delete(c.items, kv.key)
+	c.unindex(kv)
+	return kv
+}
+
+// entryOf returns the entry held by elem. A node holding anything else can only be the result
+// of a bug; it is logged and reported as missing instead of crashing the process.
+func (c *Cache) entryOf(elem listNode) (*entry, bool) {
+	kv, ok := c.eviction.value(elem).(*entry)
+	if !ok {
+		c.logf("scache: eviction list node holds %T instead of an entry", c.eviction.value(elem))
+	}
+	return kv, ok
+}
+
+// lookup returns the eviction list node and entry stored for key. The caller must hold the
+// lock.
+func (c *Cache) lookup(key string) (listNode, *entry, bool) {
+	elem, found := c.items[key]
+	if !found {
+		return nil, nil, false
+	}
+	kv, ok := c.entryOf(elem)
+	return elem, kv, ok
+}
+
+// lookupLive is like lookup but reports expired entries as missing, removing them unless the
+// cache is frozen. The caller must hold the lock.
+func (c *Cache) lookupLive(key string, now time.Time) (listNode, *entry, bool) {
+	elem, kv, found := c.lookup(key)
+	if !found || !c.expired(kv.value, now) {
+		return elem, kv, found
+	}
+	if !c.frozen.Load() {
+		c.remove(elem, Expired)
+	}
+	return nil, nil, false
+}
+
+// lockLookup acquires the lock for a lookup that promotes or removes entries. A frozen cache
+// is left unchanged by lookups, so the read lock suffices then. lockLookup reports whether it
+// took the read lock, which the caller passes on to unlockLookup.
+func (c *Cache) lockLookup() (shared bool) {
+	if c.frozen.Load() {
+		c.mu.RLock()
+		return true
+	}
+	c.lock()
+	return false
+}
+
+// unlockLookup releases the lock acquired by lockLookup.
+func (c *Cache) unlockLookup(shared bool) {
+	if shared {
+		c.mu.RUnlock()
+	} else {
+		c.unlock()
+	}
+}
+
+// logf logs through the logger configured with WithLogger, if any.
+func (c *Cache) logf(format string, v ...any) {
+	if c.logger != nil {
+		c.logger.Printf(format, v...)
+	}
+}
+
+// unindex removes kv from the value index unless another key has claimed its value since.
+func (c *Cache) unindex(kv *entry) {
+	if c.valueIndex != nil && c.valueIndex[kv.value.Value] == kv.key {
+		delete(c.valueIndex, kv.value.Value)
+	}
+}
+
+// hit records an access to the entry kv held by elem and moves elem to the front of the
+// eviction list once it has been accessed as often as configured with
+// WithPromotionThreshold. On a frozen cache it only counts the hit. The caller must hold the
+// lock.
+func (c *Cache) hit(elem listNode, kv *entry, now time.Time) {
+	c.countLookup(true)
+	if c.frozen.Load() {
+		return
+	}
+	kv.lastAccess = now
+	if c.topK != nil {
+		c.topK.record(kv.key)
+	}
+	if kv.accessCount < math.MaxUint64 {
+		kv.accessCount++
+	}
+	if c.promoteAfter > 1 {
+		kv.accesses++
+		if kv.accesses < c.promoteAfter {
+			return
+		}
+		kv.accesses = 0
+	}
+	if c.eviction.front() != elem {
+		c.eviction.moveToFront(elem)
+		c.listOps.promotions.Add(1)
+	}
+}
+
+// remove deletes elem from the cache and queues its eviction callbacks. The caller must hold
+// the lock and release it with unlock so the callbacks run.
+func (c *Cache) remove(elem listNode, reason EvictReason) {
+	kv := c.unlink(elem)
+	if kv == nil {
+		return
+	}
+	if reason == Deleted && c.tombstones != nil {
+		c.tombstones[kv.key] = c.now()
+	}
+	if c.onEvict != nil || (kv.onExpire != nil && (reason == Expired || reason == Evicted)) || (c.spiller != nil && reason == Evicted) {
+		c.pending = append(c.pending, evicted{kv, reason})
+	}
+}
+
+// unlock releases the write lock and then runs the callbacks of the entries removed while it
+// was held, so callbacks are free to call back into the cache.
+func (c *Cache) unlock() {
+	pending := c.pending
+	c.pending = nil
+	var highWater func(size, capacity int)
+	size, capacity := len(c.items), c.capacity
+	if c.highWater != nil && c.highWater.check(size, capacity) {
+		highWater = c.highWater.fn
+	}
+	var transition bool
+	if c.onEmpty != nil {
+		empty := len(c.items) == 0
+		transition = c.isEmpty.Swap(empty) != empty
+	}
+	c.mu.Unlock()
+
+	if highWater != nil {
+		c.safely("high water mark", func() { highWater(size, capacity) })
+	}
+	if transition {
+		c.reportEmpty()
+	}
+
+	for _, ev := range pending {
+		kv := ev.entry
+		if c.spiller != nil && ev.reason == Evicted {
+			c.safely("Spill", func() {
+				if err := c.spiller.Spill(kv.key, kv.value.Value, kv.value.ExpiryTime); err != nil {
+					c.logf("scache: spilling key %q failed: %v", kv.key, err)
+				}
+			})
+		}
+		if kv.onExpire != nil && (ev.reason == Expired || ev.reason == Evicted) {
+			c.safely("expiry", func() { kv.onExpire(kv.key, kv.value.Value) })
+		}
+		if c.onEvict != nil {
+			c.safely("OnEvict", func() { c.onEvict(kv.key, kv.value.Value, ev.reason) })
+		}
+	}
+}
+
+// reportEmpty calls the hook configured with WithEmptyTransitionHook until it has been told
+// the latest emptiness state. Only one goroutine runs the hook at a time; others leave the
+// reporting to it, so the hook sees the transitions in order, may call back into the cache and
+// skips states that were superseded while it ran.
+func (c *Cache) reportEmpty() {
+	c.emptyMu.Lock()
+	if c.emptyReporting {
+		c.emptyMu.Unlock()
+		return
+	}
+	c.emptyReporting = true
+	for {
+		empty := c.isEmpty.Load()
+		if empty == c.emptyReported {
+			c.emptyReporting = false
+			c.emptyMu.Unlock()
+			return
+		}
+		c.emptyReported = empty
+		c.emptyMu.Unlock()
+		c.safely("empty transition", func() { c.onEmpty(empty) })
+		c.emptyMu.Lock()
+	}
+}
+
+// safely runs the user callback fn, recovering from a panic and logging it, so a faulty
+// callback can neither crash the process nor keep the remaining callbacks from running.
+// Callbacks are only ever run without the cache lock held.
+func (c *Cache) safely(name string, fn func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.logf("scache: %s callback panicked: %v", name, r)
+		}
+	}()
+	fn()
+}
+
+// miss reports a miss of key to the callback configured with WithOnMiss, if any.
+func (c *Cache) miss(key string) {
+	if c.onMiss != nil {
+		c.safely("OnMiss", func() { c.onMiss(key) })
+	}
+}
+
+// Get retrieves a cache entry by its key. It returns the value and a boolean indicating whether the key was found.
+func (c *Cache) Get(key string) (string, error) {
+	return c.GetAt(key, c.now())
+}
+
+// GetAt is like Get but decides whether the entry has expired using the given time instead
+// of the cache clock.
+func (c *Cache) GetAt(key string, now time.Time) (string, error) {
+	return c.get(c.normalize(key), now)
+}
+
+// get implements GetAt for a key that has already been normalized.
+func (c *Cache) get(key string, now time.Time) (string, error) {
+	value, err := c.getAt(key, now)
+	if err == nil {
+		return value, nil
+	}
+	return c.handleMiss(key, now, err)
+}
+
+// handleMiss completes a lookup of a normalized key missing from the cache: it returns the
+// item restored from the fallback cache or the spiller, if any, and otherwise records the
+// miss and returns err. It must be called without the lock.
+func (c *Cache) handleMiss(key string, now time.Time, err error) (string, error) {
+	if item, ok := c.restore(key, now); ok {
+		// Copying the entry back is best effort; the value is returned even if it fails.
+		if c.validate(item.Value, 0) == nil {
+			_ = c.put(&entry{key: key, value: item})
+		}
+		return item.Value, nil
+	}
+	c.countLookup(false)
+	c.miss(key)
+	return "", err
+}
+
+// restore looks a key missing from the cache up in the fallback cache and the spiller, in
+// that order, and returns the first live item found. It must be called without the lock.
+func (c *Cache) restore(key string, now time.Time) (CacheItem, bool) {
+	if c.fallback != nil {
+		if item, err := c.fallback.GetItem(key); err == nil {
+			return CacheItem{Value: item.Value, ExpiryTime: item.ExpiryTime}, true
+		}
+	}
+	if c.spiller != nil {
+		value, expiry, ok := c.spiller.Restore(key)
+		if item := (CacheItem{Value: value, ExpiryTime: expiry}); ok && !item.expired(now) {
+			return item, true
+		}
+	}
+	return CacheItem{}, false
+}
+
+// getAt implements GetAt for a normalized key.
+func (c *Cache) getAt(key string, now time.Time) (string, error) {
+	shared := c.lockLookup()
+	defer c.unlockLookup(shared)
+	return c.getLocked(key, now)
+}
+
+// getLocked looks up a normalized key, removing it if it has expired and promoting it
+// otherwise. The caller must hold the lock.
+func (c *Cache) getLocked(key string, now time.Time) (string, error) {
+	elem, kv, found := c.lookupLive(key, now)
+	if !found {
+		return "", ErrNotFound
+	}
+	// Move the accessed element to the front of the eviction list
+	c.hit(elem, kv, now)
+	return kv.value.Value, nil
+}
+
+// GetRefreshIfBelow retrieves a cache entry like Get and, if its remaining TTL is below floor,
+// extends it to extendTo from now. Unlike refreshing on every access, this only rewrites the
+// expiry of active entries when they come close to expiring. Entries that never expire are
+// left unchanged. It returns ErrInvalidTTL if extendTo is not positive.
+func (c *Cache) GetRefreshIfBelow(key string, floor, extendTo time.Duration) (string, error) {
+	if extendTo <= 0 {
+		return "", ErrInvalidTTL
+	}
+	key = c.normalize(key)
+	shared := c.lockLookup()
+	defer c.unlockLookup(shared)
+	now := c.now()
+	elem, kv, found := c.lookupLive(key, now)
+	if !found {
+		c.countLookup(false)
+		return "", ErrNotFound
+	}
+	c.hit(elem, kv, now)
+	if expiry := kv.value.ExpiryTime; !expiry.IsZero() && expiry.Sub(now) < floor && !c.frozen.Load() {
+		kv.value.ExpiryTime = c.clampExpiry(key, now.Add(extendTo), now)
+		kv.modified = now
+	}
+	return kv.value.Value, nil
+}
+
+// GetOrDefault returns the value stored for key, or def if the key is missing or expired.
+// A hit promotes the entry exactly like Get.
+func (c *Cache) GetOrDefault(key, def string) string {
+	value, err := c.Get(key)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// GetWithVersion retrieves a cache entry like Get and also returns its version.
+func (c *Cache) GetWithVersion(key string) (value string, version uint64, err error) {
+	key = c.normalize(key)
+	shared := c.lockLookup()
+	defer c.unlockLookup(shared)
+	now := c.now()
+	elem, kv, found := c.lookupLive(key, now)
+	if !found {
+		c.countLookup(false)
+		return "", 0, ErrNotFound
+	}
+	c.hit(elem, kv, now)
+	return kv.value.Value, kv.value.Version, nil
+}
+
+// GetWithMeta retrieves a cache entry like Get and also returns a copy of the metadata stored
+// with SetWithMeta, which is nil for entries stored without metadata.
+func (c *Cache) GetWithMeta(key string) (value string, meta map[string]string, err error) {
+	key = c.normalize(key)
+	shared := c.lockLookup()
+	defer c.unlockLookup(shared)
+	now := c.now()
+	elem, kv, found := c.lookupLive(key, now)
+	if !found {
+		c.countLookup(false)
+		return "", nil, ErrNotFound
+	}
+	c.hit(elem, kv, now)
+	return kv.value.Value, maps.Clone(kv.meta), nil
+}
+
+// GetRef returns the value stored for key like Get and pins the entry so it is not evicted to
+// make room for other entries until release is called, e.g. while the value is handed to a
+// reader that relies on it staying cached. Pins are counted, so an entry stays pinned until
+// every GetRef reference to it has been released. Pinning does not stop the entry from
+// expiring, being deleted or being replaced by a write, which ends the pin; and if every entry
+// is pinned, the cache grows beyond its capacity. GetRef does not remove expired entries.
+//
+// Forgetting to call release pins the entry for as long as release stays reachable. As a
+// safety net the entry is released once release has been garbage collected, but callers
+// should not rely on that and call release when done, typically with defer. Calling release
+// more than once has no effect.
+func (c *Cache) GetRef(key string) (value string, release func(), err error) {
+	key = c.normalize(key)
+	shared := c.lockLookup()
+	defer c.unlockLookup(shared)
+
+	now := c.now()
+	elem, kv, found := c.lookup(key)
+	if !found || c.expired(kv.value, now) {
+		c.countLookup(false)
+		return "", nil, ErrNotFound
+	}
+	c.hit(elem, kv, now)
+	if c.frozen.Load() {
+		// Nothing is evicted from a frozen cache, so there is nothing to pin.
+		return kv.value.Value, func() {}, nil
+	}
+	kv.pins++
+
+	p := &pin{c: c, kv: kv}
+	runtime.SetFinalizer(p, (*pin).release)
+	return kv.value.Value, p.release, nil
+}
+
+// pin is a reference to an entry handed out by GetRef.
+type pin struct {
+	c    *Cache
+	kv   *entry
+	once sync.Once
+}
+
+// release unpins the entry. It is also the finalizer of p.
+func (p *pin) release() {
+	p.once.Do(func() {
+		runtime.SetFinalizer(p, nil)
+		p.c.lock()
+		p.kv.pins--
+		p.c.mu.Unlock()
+	})
+}
+
+// GetMultiWithExpiry returns the value and expiry time of every live key in keys under a
+// single lock acquisition. Missing and expired keys are omitted from the result. Like Get,
+// it promotes the returned entries in the LRU order and removes expired ones; use
+// PeekMultiWithExpiry to leave the order untouched.
+func (c *Cache) GetMultiWithExpiry(keys []string) map[string]CacheItem {
+	shared := c.lockLookup()
+	defer c.unlockLookup(shared)
+	now := c.now()
+	result := make(map[string]CacheItem, len(keys))
+	for _, key := range keys {
+		elem, kv, found := c.lookupLive(c.normalize(key), now)
+		if !found {
+			c.countLookup(false)
+			continue
+		}
+		c.hit(elem, kv, now)
+		result[key] = kv.value
+	}
+	return result
+}
+
+// PeekMultiWithExpiry is like GetMultiWithExpiry but only takes the read lock and neither
+// promotes nor removes any entry.
+func (c *Cache) PeekMultiWithExpiry(keys []string) map[string]CacheItem {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.now()
+	result := make(map[string]CacheItem, len(keys))
+	for _, key := range keys {
+		_, kv, found := c.lookup(c.normalize(key))
+		if found && !c.expired(kv.value, now) {
+			result[key] = kv.value
+		}
+	}
+	return result
+}
+
+// GetOrSet returns the value stored for key or, on a miss, calls loader and stores its result
+// with the given TTL. Concurrent misses on the same key share a single loader call: the first
+// caller runs it and all others wait and are released together once it completes. If the
+// loader panics, the panic is recovered and every caller receives ErrLoaderPanic.
+//
+// In-flight loads are tracked under a lock of their own and no lock is held while a loader
+// runs, so loads for different keys proceed fully in parallel.
+func (c *Cache) GetOrSet(key string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	if ttl < 0 {
+		return "", ErrInvalidTTL
+	}
+	return c.GetOrLoad(key, func() (string, time.Duration, error) {
+		value, err := loader()
+		return value, ttl, err
+	})
+}
+
+// GetOrLoad is like GetOrSet, but loader also returns the TTL to store the value with, e.g.
+// derived from the freshness information of an upstream response. A zero TTL means the value
+// never expires and a negative TTL means the value is returned without being cached.
+//
+// If an entry that is still live is refreshed early because of WithEarlyExpiration and the
+// loader fails or panics, GetOrLoad returns the cached value instead of the error.
+func (c *Cache) GetOrLoad(key string, loader func() (value string, ttl time.Duration, err error)) (string, error) {
+	return c.getOrLoad(context.Background(), key, loader)
+}
+
+// getOrLoad implements GetOrLoad and GetOrLoadCtx.
+func (c *Cache) getOrLoad(ctx context.Context, key string, loader func() (string, time.Duration, error)) (string, error) {
+	key = c.normalize(key)
+	value, err := c.get(key, c.now())
+	live := err == nil
+	if live && !c.expiresEarly(key) {
+		return value, nil
+	}
+
+	c.callsMu.Lock()
+	cl, found := c.calls[key]
+	if found {
+		c.callsMu.Unlock()
+		select {
+		case <-cl.done:
+		case <-ctx.Done():
+			if live {
+				return value, nil
+			}
+			return "", ctx.Err()
+		}
+	} else {
+		cl = &call{done: make(chan struct{})}
+		c.calls[key] = cl
+		c.callsMu.Unlock()
+		c.load(ctx, key, cl, loader)
+	}
+	if cl.err != nil && live {
+		return value, nil
+	}
+	return cl.value, cl.err
+}
+
+// load runs loader for cl, stores the result and releases the waiters of cl. If ctx is done
+// before a load slot is free, loader is not called and cl fails with ctx.Err().
+func (c *Cache) load(ctx context.Context, key string, cl *call, loader func() (string, time.Duration, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			cl.value, cl.err = "", fmt.Errorf("%w: %v", ErrLoaderPanic, r)
+		}
+		c.callsMu.Lock()
+		delete(c.calls, key)
+		c.callsMu.Unlock()
+		close(cl.done)
+	}()
+	if cl.err = c.acquireLoad(ctx); cl.err != nil {
+		return
+	}
+	defer c.releaseLoad()
+
+	start := c.now()
+	var ttl time.Duration
+	cl.value, ttl, cl.err = loader()
+	if cl.err != nil || ttl < 0 {
+		return
+	}
+	if cl.err = c.validate(cl.value, ttl); cl.err != nil {
+		return
+	}
+	now := c.now()
+	item := CacheItem{
+		Value:      cl.value,
+		ExpiryTime: expiryTime(now, ttl),
+	}
+	cl.err = c.put(&entry{key: key, value: item, delta: now.Sub(start)})
+}
+
+// GetOrSetMulti returns the values stored for keys, calling loader once with the keys that
+// are missing, as passed to GetOrSetMulti, and storing the values it returns with the given
+// TTL. Keys that loader does not return are left out of the result. Loads are shared with
+// concurrent GetOrSet, GetOrLoad and GetOrSetMulti calls: keys that are already being loaded
+// are waited for instead of being passed to loader, so overlapping batches load every key
+// once. If loader returns an error or panics, no key is stored and the error, or
+// ErrLoaderPanic, is returned for the keys it was asked for.
+//
+// GetOrSetMulti returns the values found along with the first error encountered, so the
+// result may be partial when the error is not nil.
+func (c *Cache) GetOrSetMulti(keys []string, ttl time.Duration, loader func(missing []string) (map[string]string, error)) (map[string]string, error) {
+	if ttl < 0 {
+		return nil, ErrInvalidTTL
+	}
+
+	result := make(map[string]string, len(keys))
+	seen := make(map[string]struct{}, len(keys))
+	var missing []string // Keys as passed, whose normalized form is not cached
+	for _, key := range keys {
+		normalized := c.normalize(key)
+		if _, dup := seen[normalized]; dup {
+			continue
+		}
+		seen[normalized] = struct{}{}
+		if value, err := c.get(normalized, c.now()); err == nil {
+			result[key] = value
+		} else {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return result, nil
+	}
+
+	var load, wait []string
+	var loadCalls, waitCalls []*call
+	c.callsMu.Lock()
+	for _, key := range missing {
+		normalized := c.normalize(key)
+		if cl, found := c.calls[normalized]; found {
+			wait, waitCalls = append(wait, key), append(waitCalls, cl)
+			continue
+		}
+		cl := &call{done: make(chan struct{})}
+		c.calls[normalized] = cl
+		load, loadCalls = append(load, key), append(loadCalls, cl)
+	}
+	c.callsMu.Unlock()
+
+	if len(load) > 0 {
+		c.loadMulti(load, loadCalls, ttl, loader)
+	}
+
+	var firstErr error
+	collect := func(keys []string, calls []*call) {
+		for i, cl := range calls {
+			<-cl.done
+			switch {
+			case cl.err == nil:
+				result[keys[i]] = cl.value
+			case !errors.Is(cl.err, ErrNotFound) && firstErr == nil:
+				firstErr = cl.err
+			}
+		}
+	}
+	collect(load, loadCalls)
+	collect(wait, waitCalls)
+	return result, firstErr
+}
+
+// loadMulti runs loader for keys, stores the values it returns and releases the waiters of
+// calls, the in-flight loads of keys in the same order.
+func (c *Cache) loadMulti(keys []string, calls []*call, ttl time.Duration, loader func([]string) (map[string]string, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			for _, cl := range calls {
+				cl.value, cl.err = "", fmt.Errorf("%w: %v", ErrLoaderPanic, r)
+			}
+		}
+		c.callsMu.Lock()
+		for _, key := range keys {
+			delete(c.calls, c.normalize(key))
+		}
+		c.callsMu.Unlock()
+		for _, cl := range calls {
+			close(cl.done)
+		}
+	}()
+	_ = c.acquireLoad(context.Background())
+	defer c.releaseLoad()
+
+	values, err := loader(keys)
+	now := c.now()
+	for i, key := range keys {
+		cl := calls[i]
+		value, found := values[key]
+		switch {
+		case err != nil:
+			cl.err = err
+		case !found:
+			cl.err = ErrNotFound
+		default:
+			cl.value = value
+			if cl.err = c.validate(value, ttl); cl.err == nil {
+				item := CacheItem{Value: value, ExpiryTime: expiryTime(now, ttl)}
+				cl.err = c.put(&entry{key: c.normalize(key), value: item})
+			}
+		}
+	}
+}
+
+// acquireLoad waits for a slot for a loader call if the number of concurrent loads is limited
+// with WithMaxConcurrentLoads. It returns ctx.Err() without taking a slot if ctx is done
+// first.
+func (c *Cache) acquireLoad(ctx context.Context) error {
+	if c.loadSlots == nil {
+		return nil
+	}
+	select {
+	case c.loadSlots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// releaseLoad frees the slot taken by acquireLoad.
+func (c *Cache) releaseLoad() {
+	if c.loadSlots != nil {
+		<-c.loadSlots
+	}
+}
+
+// expiresEarly decides whether GetOrLoad treats the live entry stored for key as expired to
+// refresh it ahead of time, as configured with WithEarlyExpiration. Following the XFetch
+// algorithm, the entry is refreshed when now - delta·beta·ln(rand) reaches its expiry time,
+// where delta is how long its loader took. The probability thus rises towards expiry and
+// with the cost of a refresh, so that usually a single caller refreshes the entry.
+func (c *Cache) expiresEarly(key string) bool {
+	if c.earlyBeta <= 0 {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, kv, found := c.lookup(key)
+	if !found || kv.value.ExpiryTime.IsZero() || kv.delta <= 0 {
+		return false
+	}
+	gap := time.Duration(float64(kv.delta) * c.earlyBeta * -math.Log(c.random()))
+	return !c.now().Add(gap).Before(kv.value.ExpiryTime)
+}
+
+// KeyForValue returns the key whose live entry holds value. It requires the cache to be
+// created with WithValueIndex and always reports false otherwise.
+func (c *Cache) KeyForValue(value string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	key, found := c.valueIndex[value]
+	if !found {
+		return "", false
+	}
+	_, kv, found := c.lookup(key)
+	if !found || c.expired(kv.value, c.now()) {
+		return "", false
+	}
+	return key, true
+}
+
+// Peek returns the value stored for key like Get, but without promoting the entry in the
+// LRU order or removing it when expired.
+func (c *Cache) Peek(key string) (string, error) {
+	key = c.normalize(key)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, kv, found := c.lookup(key)
+	if !found || c.expired(kv.value, c.now()) {
+		return "", ErrNotFound
+	}
+	return kv.value.Value, nil
+}
+
+// GetItem returns the full cache item stored for key, including its expiry time, without
+// promoting the entry in the LRU order.
+func (c *Cache) GetItem(key string) (CacheItem, error) {
+	key = c.normalize(key)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, kv, found := c.lookup(key)
+	if !found || c.expired(kv.value, c.now()) {
+		return CacheItem{}, ErrNotFound
+	}
+	return kv.value, nil
+}
+
+// TTL returns the remaining time to live of the entry stored for key.
+// It returns 0 for an entry that never expires. While expiry is paused with PauseExpiry, it
+// returns the negative remaining time of entries past their expiry time instead of
+// ErrNotFound.
+func (c *Cache) TTL(key string) (time.Duration, error) {
+	key = c.normalize(key)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, kv, found := c.lookup(key)
+	if !found {
+		return 0, ErrNotFound
+	}
+	item := kv.value
+	if item.ExpiryTime.IsZero() {
+		return 0, nil
+	}
+	ttl := item.ExpiryTime.Sub(c.now())
+	if ttl < 0 && !c.expiryPaused.Load() {
+		return 0, ErrNotFound
+	}
+	return ttl, nil
+}
+
+// EntryInfo returns when the entry stored for key was created, when it was last returned by
+// a lookup such as Get and how many lookups returned it. Updates of a key keep its history.
+// The access count saturates at math.MaxUint64 instead of wrapping around. EntryInfo neither
+// promotes the entry nor counts as an access; ok is false if the key is missing or expired.
+func (c *Cache) EntryInfo(key string) (created, lastAccess time.Time, accessCount uint64, ok bool) {
+	key = c.normalize(key)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, kv, found := c.lookup(key)
+	if !found || c.expired(kv.value, c.now()) {
+		return time.Time{}, time.Time{}, 0, false
+	}
+	return kv.created, kv.lastAccess, kv.accessCount, true
+}
+
+// IsExpired reports whether key is present in the cache and, if so, whether its entry has
+// expired. It neither removes nor promotes the entry, so expired entries that have not been
+// swept yet can be inspected before they are removed.
+func (c *Cache) IsExpired(key string) (expired bool, present bool) {
+	key = c.normalize(key)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, kv, found := c.lookup(key)
+	if !found {
+		return false, false
+	}
+	return c.expired(kv.value, c.now()), true
+}
+
+// Expire sets the absolute expiry time of the entry stored for key without changing its
+// value. A zero time makes the entry never expire. It returns ErrNotFound if the key is
+// missing or has expired.
+func (c *Cache) Expire(key string, at time.Time) error {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+	if c.frozen.Load() {
+		return ErrFrozen
+	}
+	elem, kv, found := c.lookup(key)
+	if !found {
+		return ErrNotFound
+	}
+	if c.expired(kv.value, c.now()) {
+		c.remove(elem, Expired)
+		return ErrNotFound
+	}
+	kv.value.ExpiryTime = c.clampExpiry(key, at, c.now())
+	kv.modified = c.now()
+	return nil
+}
+
+// Persist removes the expiry of the entry stored for key so it never expires. It returns
+// ErrNotFound if the key is missing or has expired.
+func (c *Cache) Persist(key string) error {
+	return c.Expire(key, time.Time{})
+}
+
+// Len returns the number of entries in the cache. Expired entries that have not been removed
+// yet are included.
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.items)
+}
+
+// Usage returns the number of entries in the cache and its capacity, sampled under a single
+// lock acquisition so the pair is consistent. Like Len, size includes expired entries that
+// have not been removed yet.
+func (c *Cache) Usage() (size, capacity int) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.items), c.capacity
+}
+
+// ApproxBytes returns the sum of the key, value and metadata lengths of all live entries. It
+// scans the whole cache under the read lock, so it is meant for occasional sampling, not hot
+// paths.
+func (c *Cache) ApproxBytes() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.now()
+	var n int64
+	for _, elem := range c.items {
+		if kv, ok := c.entryOf(elem); ok && !c.expired(kv.value, now) {
+			n += int64(kv.size())
+		}
+	}
+	return n
+}
+
+// Contains checks if cached key exists in the cache. It only takes the read lock, does not
+// allocate and, unlike Get, neither promotes the entry nor removes it when expired.
+func (c *Cache) Contains(key string) bool {
+	key = c.normalize(key)
+	if c.contains(key) {
+		return true
+	}
+	c.miss(key)
+	return false
+}
+
+// contains implements Contains for a normalized key.
+func (c *Cache) contains(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, kv, found := c.lookup(key)
+	return found && !c.expired(kv.value, c.now())
+}
+
+// ContainsAll reports for each of keys whether it exists in the cache, in the order of keys.
+// It checks all keys under a single read lock, allocates only the result and, like Contains,
+// neither promotes nor removes entries.
+func (c *Cache) ContainsAll(keys []string) []bool {
+	found := make([]bool, len(keys))
+	c.mu.RLock()
+	now := c.now()
+	for i, key := range keys {
+		_, kv, ok := c.lookup(c.normalize(key))
+		found[i] = ok && !c.expired(kv.value, now)
+	}
+	c.mu.RUnlock()
+
+	if c.onMiss != nil {
+		for i, key := range keys {
+			if !found[i] {
+				c.miss(c.normalize(key))
+			}
+		}
+	}
+	return found
+}
+
+// Delete removes the entry with the given key. It reports whether the key was present.
+// The global eviction callback is called with reason Deleted.
+func (c *Cache) Delete(key string) bool {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+	if c.frozen.Load() {
+		return false
+	}
+	elem, _, found := c.lookup(key)
+	if found {
+		c.remove(elem, Deleted)
+	}
+	return found
+}
+
+// GetAndDelete retrieves the value stored for key and removes the entry under a single lock,
+// so concurrent callers never both receive the value. It returns ErrNotFound if the key is
+// missing or expired. The global eviction callback is called with reason Deleted.
+func (c *Cache) GetAndDelete(key string) (string, error) {
+	key = c.normalize(key)
+	c.lock()
+	defer c.unlock()
+	if c.frozen.Load() {
+		return "", ErrFrozen
+	}
+	elem, kv, found := c.lookup(key)
+	if !found || c.expired(kv.value, c.now()) {
+		if found {
+			c.remove(elem, Expired)
+		}
+		return "", ErrNotFound
+	}
+	c.remove(elem, Deleted)
+	return kv.value.Value, nil
+}
+
+// DeleteMany removes the entries with the given keys under a single lock and returns the
+// number of removed entries. Missing keys are skipped. The global eviction callback is called
+// with reason Deleted for every removed entry.
+func (c *Cache) DeleteMany(keys []string) int {
+	c.lock()
+	defer c.unlock()
+	if c.frozen.Load() {
+		return 0
+	}
+	removed := 0
+	for _, key := range keys {
+		if elem, _, found := c.lookup(c.normalize(key)); found {
+			c.remove(elem, Deleted)
+			removed++
+		}
+	}
+	return removed
+}
+
+// DeleteMatch removes every live entry whose key matches the glob pattern, using the syntax
+// of path.Match, and returns the number of removed entries. It returns path.ErrBadPattern
+// for a malformed pattern. DeleteMatch scans the whole cache and is not meant for hot paths.
+func (c *Cache) DeleteMatch(pattern string) (int, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return 0, err
+	}
+
+	c.lock()
+	defer c.unlock()
+	if c.frozen.Load() {
+		return 0, ErrFrozen
+	}
+	now := c.now()
+	removed := 0
+	for key, elem := range c.items {
+		if kv, ok := c.entryOf(elem); !ok || c.expired(kv.value, now) {
+			continue
+		}
+		if matched, _ := path.Match(pattern, key); matched {
+			c.remove(elem, Deleted)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// FlushFunc removes every live entry for which pred returns true and returns the number of
+// removed entries. The global eviction callback is called with reason Deleted for each of them.
+// Keys marked with Protect are skipped without calling pred. pred runs while the cache lock is
+// held, so it must not call back into the cache.
+func (c *Cache) FlushFunc(pred func(key, value string, expiry time.Time) bool) int {
+	c.lock()
+	defer c.unlock()
+	if c.frozen.Load() {
+		return 0
+	}
+	now := c.now()
+	removed := 0
+	for key, elem := range c.items {
+		kv, ok := c.entryOf(elem)
+		if !ok || c.expired(kv.value, now) || c.isProtected(key) {
+			continue
+		}
+		if pred(key, kv.value.Value, kv.value.ExpiryTime) {
+			c.remove(elem, Deleted)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Keys returns the keys of all live entries in no particular order.
+func (c *Cache) Keys() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.now()
+	keys := make([]string, 0, len(c.items))
+	for key, elem := range c.items {
+		if kv, ok := c.entryOf(elem); ok && !c.expired(kv.value, now) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// GetByPrefix returns the keys and values of all live entries whose key starts with prefix,
+// read under a single lock acquisition so the result is consistent. Like Peek it does not
+// promote the entries in the LRU order. It scans the whole cache.
+func (c *Cache) GetByPrefix(prefix string) map[string]string {
+	prefix = c.normalize(prefix)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.now()
+	result := make(map[string]string)
+	for key, elem := range c.items {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if kv, ok := c.entryOf(elem); ok && !c.expired(kv.value, now) {
+			result[key] = kv.value.Value
+		}
+	}
+	return result
+}
+
+// SortedEntries returns the keys and values of all live entries sorted by key, e.g. for
+// deterministic output in tests. It does not promote the entries.
+func (c *Cache) SortedEntries() []KV {
+	c.mu.RLock()
+	now := c.now()
+	entries := make([]KV, 0, len(c.items))
+	for key, elem := range c.items {
+		if kv, ok := c.entryOf(elem); ok && !c.expired(kv.value, now) {
+			entries = append(entries, KV{Key: key, Value: kv.value.Value})
+		}
+	}
+	c.mu.RUnlock()
+
+	slices.SortFunc(entries, func(a, b KV) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return entries
+}
+
+// ForEach calls fn for every live entry until fn returns false. The entries are collected
+// under the read lock first and fn is called after it has been released, so ForEach always
+// sees a consistent snapshot: a concurrent Flush either happens entirely before the snapshot
+// or is not observed at all. Since no lock is held while fn runs, fn may call back into the
+// cache, including Flush, without deadlocking.
+func (c *Cache) ForEach(fn func(key, value string) bool) {
+	c.mu.RLock()
+	now := c.now()
+	snapshot := make([]entry, 0, len(c.items))
+	for _, elem := range c.items {
+		if kv, ok := c.entryOf(elem); ok && !c.expired(kv.value, now) {
+			snapshot = append(snapshot, entry{key: kv.key, value: kv.value})
+		}
+	}
+	c.mu.RUnlock()
+
+	for _, kv := range snapshot {
+		if !fn(kv.key, kv.value.Value) {
+			return
+		}
+	}
+}
+
+// ForEachLRU calls fn for every live entry from the least to the most recently used one,
+// until fn returns false. Unlike ForEach, the read lock is held during the traversal, so fn
+// must not call back into the cache.
+func (c *Cache) ForEachLRU(fn func(key, value string) bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.now()
+	for elem := c.eviction.back(); elem != nil; elem = c.eviction.prev(elem) {
+		kv, ok := c.entryOf(elem)
+		if !ok || c.expired(kv.value, now) {
+			continue
+		}
+		if !fn(kv.key, kv.value.Value) {
+			return
+		}
+	}
+}
+
+// MostRecent returns the keys of up to n live entries, starting with the most recently used
+// one, without changing the order. Recency is defined as for eviction: entries are used when
+// they are stored or returned by Get and the other promoting lookups, not by Peek or Contains.
+func (c *Cache) MostRecent(n int) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.now()
+	keys := make([]string, 0, min(max(n, 0), len(c.items)))
+	for elem := c.eviction.front(); elem != nil && len(keys) < n; elem = c.eviction.next(elem) {
+		if kv, ok := c.entryOf(elem); ok && !c.expired(kv.value, now) {
+			keys = append(keys, kv.key)
+		}
+	}
+	return keys
+}
+
+// OrderedKeys returns the keys of all live entries in eviction order, from the most recently
+// used one at the front to the least recently used one, the next to be evicted, at the back.
+// It does not change the order, so storing the keys in reverse into an empty cache of the same
+// capacity reproduces it.
+func (c *Cache) OrderedKeys() []string {
+	return c.MostRecent(math.MaxInt)
+}
+
+// Flush removes all cached keys of the cache, except those marked with Protect. No eviction
+// callbacks are called.
+func (c *Cache) Flush() error {
+	c.lock()
+	defer c.unlock()
+	if c.frozen.Load() {
+		return ErrFrozen
+	}
+	var kept []*entry
+	if len(c.protected) > 0 {
+		for elem := c.eviction.front(); elem != nil; elem = c.eviction.next(elem) {
+			if kv, ok := c.entryOf(elem); ok && c.isProtected(kv.key) {
+				kept = append(kept, kv)
+			}
+		}
+	}
+	c.items = make(map[string]listNode, len(kept))
+	c.eviction = c.newEvictionList()
+	c.sweepCursor = nil
+	if c.valueIndex != nil {
+		c.valueIndex = make(map[string]string, len(kept))
+	}
+	for _, kv := range kept {
+		c.items[kv.key] = c.eviction.pushBack(kv)
+		if c.valueIndex != nil {
+			c.valueIndex[kv.value.Value] = kv.key
+		}
+	}
+	return nil
+}
+
+// Protect exempts keys from Flush and FlushFunc, e.g. to keep feature flags and configuration
+// when clearing user data. Protection applies to the keys, not to their current entries, so it
+// also covers entries stored later, until Unprotect is called. Protected entries still expire,
+// are evicted and can be deleted as usual.
+func (c *Cache) Protect(keys ...string) {
+	c.lock()
+	defer c.mu.Unlock()
+	if c.protected == nil {
+		c.protected = make(map[string]struct{}, len(keys))
+	}
+	for _, key := range keys {
+		c.protected[c.normalize(key)] = struct{}{}
+	}
+}
+
+// Unprotect removes the protection added by Protect from keys.
+func (c *Cache) Unprotect(keys ...string) {
+	c.lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		delete(c.protected, c.normalize(key))
+	}
+}
+
+// IsProtected reports whether key has been marked with Protect.
+func (c *Cache) IsProtected(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.isProtected(c.normalize(key))
+}
+
+// isProtected implements IsProtected for a normalized key. The caller must hold the lock.
+func (c *Cache) isProtected(key string) bool {
+	_, found := c.protected[key]
+	return found
+}
+
+// SwapContents replaces the entries of the cache with those of other in one step, so readers
+// see either the old or the new entries but never a mix, and leaves other empty. Entries
+// beyond the capacity of the cache are evicted in LRU order. The global eviction callback is
+// called with reason Deleted for every discarded entry. Both caches should normalize keys the
+// same way. SwapContents does nothing if either cache is frozen.
+func (c *Cache) SwapContents(other *Cache) {
+	if other == c || c.frozen.Load() {
+		return
+	}
+
+	// Take the entries out of other first so the two locks are never held together.
+	other.lock()
+	if other.frozen.Load() {
+		other.mu.Unlock()
+		return
+	}
+	items, eviction := other.items, other.eviction
+	other.items = make(map[string]listNode)
+	other.eviction = other.newEvictionList()
+	other.sweepCursor = nil
+	if other.valueIndex != nil {
+		other.valueIndex = make(map[string]string)
+	}
+	other.unlock()
+
+	c.lock()
+	if c.frozen.Load() {
+		// The cache was frozen in between, so the entries go back to other.
+		c.unlock()
+		other.reclaim(eviction)
+		return
+	}
+	defer c.unlock()
+	if c.onEvict != nil {
+		for elem := c.eviction.front(); elem != nil; elem = c.eviction.next(elem) {
+			if kv, ok := c.entryOf(elem); ok {
+				c.pending = append(c.pending, evicted{kv, Deleted})
+			}
+		}
+	}
+	c.items, c.eviction = items, eviction
+	c.sweepCursor = nil
+	if c.valueIndex != nil {
+		c.valueIndex = make(map[string]string, len(c.items))
+		for elem := c.eviction.front(); elem != nil; elem = c.eviction.next(elem) {
+			if kv, ok := c.entryOf(elem); ok {
+				c.valueIndex[kv.value.Value] = kv.key
+			}
+		}
+	}
+	c.evictLRU(len(c.items) - c.capacity)
+	for key := range c.waiters {
+		if _, found := c.items[key]; found {
+			c.notifyWaiters(key)
+		}
+	}
+}
+
+// reclaim puts back the entries of eviction that SwapContents took out of the cache, behind
+// those stored since. Keys stored or deleted since are left as they are.
+func (c *Cache) reclaim(eviction evictionList) {
+	c.lock()
+	defer c.unlock()
+	for elem := eviction.front(); elem != nil; {
+		next := eviction.next(elem)
+		if kv, ok := eviction.value(elem).(*entry); ok {
+			_, found := c.items[kv.key]
+			_, deleted := c.tombstones[kv.key]
+			if !found && !deleted {
+				eviction.remove(elem)
+				c.items[kv.key] = c.eviction.pushBack(kv)
+				if c.valueIndex != nil {
+					c.valueIndex[kv.value.Value] = kv.key
+				}
+			}
+		}
+		elem = next
+	}
+	c.evictLRU(len(c.items) - c.capacity)
+}
+
+// Compact rebuilds the internal map and eviction list so they contain only live entries,
+// releasing memory retained after the cache shrank from a large peak. The recency order of
+// the remaining entries is preserved.
+//
+// Compact is O(n) and holds the write lock for its whole duration, so it is only worth
+// calling occasionally, e.g. after a burst of churn in a long-running process.
+func (c *Cache) Compact() {
+	c.lock()
+	defer c.unlock()
+
+	now := c.now()
+	items := make(map[string]listNode, c.eviction.len())
+	eviction := c.newEvictionList()
+	for elem := c.eviction.front(); elem != nil; {
+		// Advance first: with an intrusive list, pushing kv to the new list relinks it.
+		kv, ok := c.entryOf(elem)
+		elem = c.eviction.next(elem)
+		if !ok {
+			continue
+		}
+		if c.expired(kv.value, now) {
+			c.unindex(kv)
+			if c.onEvict != nil || kv.onExpire != nil {
+				c.pending = append(c.pending, evicted{kv, Expired})
+			}
+			continue
+		}
+		items[kv.key] = eviction.pushBack(kv)
+	}
+	c.items = items
+	c.eviction = eviction
+	c.sweepCursor = nil
+}
+
+// ConflictPolicy determines how Merge resolves keys that exist in both caches.
+type ConflictPolicy int
+
+const (
+	// KeepExisting keeps the entry already stored in the destination cache.
+	KeepExisting ConflictPolicy = iota
+	// Overwrite replaces the destination entry with the one from the source cache.
+	Overwrite
+	// KeepNewerExpiry keeps whichever of the two entries expires later.
+	KeepNewerExpiry
+)
+
+// Merge copies all live entries from other into the cache, resolving keys present in both
+// according to onConflict. Expired entries in other are skipped and the capacity of the
+// cache is respected by evicting least recently used items as needed. Merged entries are
+// stored like writes: expiry times beyond the maximum TTL of the cache are clamped, the
+// history and version of replaced keys are kept and their tombstones are cleared, and the
+// versions of other are not copied. Merge does nothing if the cache is frozen.
+func (c *Cache) Merge(other *Cache, onConflict ConflictPolicy) {
+	if other == nil || other == c || c.frozen.Load() {
+		return
+	}
+
+	// Snapshot the source first so the two locks are never held together.
+	now := c.now()
+	other.mu.RLock()
+	entries := make([]entry, 0, other.eviction.len())
+	for elem := other.eviction.back(); elem != nil; elem = other.eviction.prev(elem) {
+		kv, ok := other.entryOf(elem)
+		if !ok || c.expired(kv.value, now) {
+			continue
+		}
+		entries = append(entries, entry{key: c.normalize(kv.key), value: kv.value, onExpire: kv.onExpire})
+	}
+	other.mu.RUnlock()
+
+	c.lock()
+	defer c.unlock()
+	if c.frozen.Load() {
+		return
+	}
+	for _, kv := range entries {
+		kv.value.ExpiryTime = c.clampExpiry(kv.key, kv.value.ExpiryTime, now)
+		if elem, found := c.items[kv.key]; found {
+			if existing, ok := c.entryOf(elem); ok && !c.expired(existing.value, now) {
+				switch onConflict {
+				case KeepExisting:
+					continue
+				case KeepNewerExpiry:
+					if !kv.value.expiresAfter(existing.value) {
+						continue
+					}
+				}
+			}
+		}
+		kv.value.Version = 0
+		c.insert(&entry{key: kv.key, value: kv.value, onExpire: kv.onExpire})
+	}
+}
+
+// Trim evicts least recently used entries until at most targetLen entries remain and returns
+// the number of evicted entries. Unlike a capacity change it is a one-off reduction: the cache
+// can grow back to its capacity afterwards. Eviction callbacks run with reason Evicted.
+// Entries pinned by GetRef are kept, so more than targetLen entries may remain.
+func (c *Cache) Trim(targetLen int) int {
+	c.lock()
+	defer c.unlock()
+	if c.frozen.Load() {
+		return 0
+	}
+	n := max(len(c.items)-max(targetLen, 0), 0)
+	before := len(c.items)
+	c.evictLRU(n)
+	return before - len(c.items)
+}
+
+// makeRoom evicts least recently used items if the cache is at capacity so one more entry
+// fits. With WithEvictionBatchSize it first removes expired entries in a batch, so after many
+// entries expired at once the following inserts do not each have to evict. The caller must
+// hold the lock.
+func (c *Cache) makeRoom() {
+	if c.eviction.len() < c.capacity {
+		return
+	}
+	if c.evictBatch > 1 {
+		c.removeExpiredLRU(c.evictBatch)
+	}
+	c.evictLRU(c.eviction.len() - c.capacity + 1)
+}
+
+// removeExpiredLRU removes the expired entries among the n least recently used ones if the
+// least recently used entry has expired, so a cache full of live entries is not scanned on
+// every insert. The caller must hold the lock.
+func (c *Cache) removeExpiredLRU(n int) {
+	now := c.now()
+	var expired []*entry
+	for elem := c.eviction.back(); elem != nil && n > 0; elem, n = c.eviction.prev(elem), n-1 {
+		kv, ok := c.entryOf(elem)
+		if ok && c.expired(kv.value, now) {
+			expired = append(expired, kv)
+		} else if len(expired) == 0 {
+			return
+		}
+	}
+	c.removeInExpiryOrder(expired, now, nil)
+}
+
+// evictLRU removes up to n least recently used items from the cache, skipping entries pinned
+// by GetRef. With WithWeightedSampledEviction each item is picked by victim instead.
+func (c *Cache) evictLRU(n int) {
+	if c.evictSample > 0 {
+		for ; n > 0; n-- {
+			elem := c.victim()
+			if elem == nil {
+				return
+			}
+			c.remove(elem, Evicted)
+		}
+		return
+	}
+	for elem := c.eviction.back(); elem != nil && n > 0; {
+		prev := c.eviction.prev(elem)
+		if kv, ok := c.entryOf(elem); !ok || kv.pins == 0 {
+			c.remove(elem, Evicted)
+			n--
+		}
+		elem = prev
+	}
+}
+
+// victim picks the next item to evict for WithWeightedSampledEviction: one of the least
+// recently used unpinned items, chosen at random with a probability proportional to 2^-weight.
+// It returns nil if every item is pinned. The caller must hold the lock.
+func (c *Cache) victim() listNode {
+	candidates := make([]listNode, 0, c.evictSample)
+	chances := make([]float64, 0, c.evictSample)
+	var total float64
+	for elem := c.eviction.back(); elem != nil && len(candidates) < c.evictSample; elem = c.eviction.prev(elem) {
+		kv, ok := c.entryOf(elem)
+		if !ok {
+			return elem
+		}
+		if kv.pins > 0 {
+			continue
+		}
+		chance := math.Exp2(-kv.weight)
+		candidates = append(candidates, elem)
+		chances = append(chances, chance)
+		total += chance
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	r := c.random() * total
+	for i, chance := range chances {
+		if r < chance {
+			return candidates[i]
+		}
+		r -= chance
+	}
+	return candidates[len(candidates)-1]
+}
+
+// checkInvariants verifies that the map and the eviction list describe the same set of
+// entries: both have the same length, every list node holds an entry whose key maps back to
+// that node and no key appears twice in the list. The caller must hold the lock.
+func (c *Cache) checkInvariants() error {
+	if len(c.items) != c.eviction.len() {
+		return fmt.Errorf("map has %d items but eviction list has %d", len(c.items), c.eviction.len())
+	}
+	seen := make(map[string]struct{}, len(c.items))
+	for elem := c.eviction.front(); elem != nil; elem = c.eviction.next(elem) {
+		kv, ok := c.eviction.value(elem).(*entry)
+		if !ok {
+			return fmt.Errorf("eviction list node holds %T, want *entry", c.eviction.value(elem))
+		}
+		if _, dup := seen[kv.key]; dup {
+			return fmt.Errorf("key %q appears more than once in the eviction list", kv.key)
+		}
+		seen[kv.key] = struct{}{}
+		if c.items[kv.key] != elem {
+			return fmt.Errorf("key %q does not map to its eviction list node", kv.key)
+		}
+	}
+	return nil
+}
+
+// Repair fixes inconsistencies between the map and the eviction list, such as list nodes that
+// do not hold an entry, keys that appear more than once in the list and map entries without a
+// node, and returns the number of removed nodes and map entries. Each repair is logged with the
+// logger configured with WithLogger. No callbacks run for the removed entries. Repair is meant
+// for recovering after HealthCheck reports a problem and scans the whole cache.
+func (c *Cache) Repair() (removed int) {
+	c.lock()
+	defer c.unlock()
+
+	kept := make(map[string]listNode, len(c.items))
+	for elem := c.eviction.front(); elem != nil; {
+		next := c.eviction.next(elem)
+		kv, ok := c.eviction.value(elem).(*entry)
+		switch {
+		case !ok:
+			c.logf("scache: repair removed an eviction list node holding %T", c.eviction.value(elem))
+			c.eviction.remove(elem)
+			removed++
+		case kept[kv.key] != nil:
+			c.logf("scache: repair removed a duplicate eviction list node for key %q", kv.key)
+			c.eviction.remove(elem)
+			removed++
+		default:
+			kept[kv.key] = elem
+		}
+		elem = next
+	}
+	for key, elem := range c.items {
+		if kept[key] == nil {
+			c.logf("scache: repair removed map entry for key %q without eviction list node", key)
+			delete(c.items, key)
+			removed++
+		} else if elem != kept[key] {
+			c.logf("scache: repair relinked key %q to its eviction list node", key)
+			c.items[key] = kept[key]
+		}
+	}
+	for key, elem := range kept {
+		if _, found := c.items[key]; !found {
+			c.logf("scache: repair restored map entry for key %q", key)
+			c.items[key] = elem
+		}
+	}
+
+	if removed > 0 {
+		c.sweepCursor = nil
+		if c.valueIndex != nil {
+			c.valueIndex = make(map[string]string, len(c.items))
+			for key, elem := range c.items {
+				c.valueIndex[c.eviction.value(elem).(*entry).value.Value] = key
+			}
+		}
+	}
+	return removed
+}
+
+// healthCheckKey is the reserved key used by HealthCheck for its round trip.
+const healthCheckKey = "\x00scache.healthcheck"
+
+// HealthCheck reports whether the cache is open, its map and eviction list agree on the number
+// of entries and a set, get and delete round trip on a reserved key succeeds. It returns an
+// error describing the first problem found. The round trip bypasses eviction, callbacks and
+// statistics, so real entries are never affected. HealthCheck runs in constant time and is
+// cheap enough for a liveness probe.
+func (c *Cache) HealthCheck() error {
+	c.lock()
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	if len(c.items) != c.eviction.len() {
+		return fmt.Errorf("map has %d items but eviction list has %d", len(c.items), c.eviction.len())
+	}
+	if _, found := c.items[healthCheckKey]; found {
+		return fmt.Errorf("reserved key %q is in use", healthCheckKey)
+	}
+
+	probe := &entry{key: healthCheckKey}
+	c.items[healthCheckKey] = c.eviction.pushFront(probe)
+	elem, kv, found := c.lookup(healthCheckKey)
+	if found {
+		c.eviction.remove(elem)
+		delete(c.items, healthCheckKey)
+	}
+	if !found || kv != probe {
+		return errors.New("round trip on the reserved key failed")
+	}
+	return nil
+}
+
+// StartEvictionTicker starts a background goroutine that periodically evicts expired items.
+// The goroutine stops when the cache is closed.
+func (c *Cache) StartEvictionTicker(d time.Duration) {
+	go c.RunEvictionLoop(context.Background(), d)
+}
+
+// RunEvictionLoop evicts expired items every d until ctx is done or the cache is closed, and
+// then stops its ticker and returns. Unlike StartEvictionTicker it blocks, so it is typically
+// run in its own goroutine tied to the lifetime of a server:
+//
+//	go cache.RunEvictionLoop(ctx, time.Minute)
+func (c *Cache) RunEvictionLoop(ctx context.Context, d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.evictExpiredItems()
+		case <-ctx.Done():
+			return
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Freeze makes the cache read-only, e.g. once it has been populated at startup. Afterwards,
+// writes, deletes, Expire, Persist and Flush return ErrFrozen, methods that do not return an
+// error report that nothing was removed, Merge and SwapContents do nothing, and queued
+// asynchronous writes are dropped. In exchange Get and the other promoting lookups such as
+// GetWithMeta, GetMultiWithExpiry and GetRef only take the read lock: they treat expired
+// entries as misses without removing them and no longer promote entries, record accesses or
+// pin entries. The eviction ticker and DrainExpired still remove expired entries to reclaim
+// memory. A frozen cache cannot be unfrozen.
+func (c *Cache) Freeze() {
+	c.lock()
+	defer c.mu.Unlock()
+	c.frozen.Store(true)
+}
+
+// Close stops the background goroutines of the cache. After Close, Set returns ErrClosed.
+// With WithAsyncWrites, Close waits until all queued writes have been applied.
+// Calling Close more than once is a no-op.
+func (c *Cache) Close() error {
+	c.lock()
+	if !c.closed.Load() {
+		c.closed.Store(true)
+		close(c.done)
+	}
+	c.mu.Unlock()
+
+	if c.writerDone != nil {
+		<-c.writerDone
+	}
+	return nil
+}
+
+// NextExpiry returns the earliest expiry time among the live entries, so an external scheduler
+// can call DrainExpired exactly when the next entry expires. It reports false if the cache has
+// no live entry that expires. NextExpiry scans the whole cache under the read lock.
+func (c *Cache) NextExpiry() (time.Time, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.now()
+	var next time.Time
+	for _, elem := range c.items {
+		kv, ok := c.entryOf(elem)
+		if !ok || kv.value.ExpiryTime.IsZero() || c.expired(kv.value, now) {
+			continue
+		}
+		if next.IsZero() || kv.value.ExpiryTime.Before(next) {
+			next = kv.value.ExpiryTime
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// ExpiryClusters counts the live entries that expire in each window-sized interval, keyed by
+// the start of the interval as returned by time.Truncate. Intervals holding a large share of
+// the entries predict a stampede of misses when they expire, which jittering the TTLs avoids.
+// Entries that never expire are not counted. ExpiryClusters returns nil if window is not
+// positive, and scans the whole cache under the read lock.
+func (c *Cache) ExpiryClusters(window time.Duration) map[time.Time]int {
+	if window <= 0 {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.now()
+	clusters := make(map[time.Time]int)
+	for _, elem := range c.items {
+		kv, ok := c.entryOf(elem)
+		if !ok || kv.value.ExpiryTime.IsZero() || c.expired(kv.value, now) {
+			continue
+		}
+		clusters[kv.value.ExpiryTime.Truncate(window)]++
+	}
+	return clusters
+}
+
+// PauseExpiry suspends expiry, e.g. while the backend that fills the cache is down for
+// maintenance, so the cache keeps serving stale entries instead of emptying out. Until
+// ResumeExpiry is called, entries past their expiry time are treated as live by all lookups
+// and no sweep removes them, so memory is only reclaimed by eviction. TTL keeps reporting the
+// actual remaining time, which is negative for such entries.
+func (c *Cache) PauseExpiry() {
+	c.expiryPaused.Store(true)
+}
+
+// ResumeExpiry ends a pause started by PauseExpiry and immediately removes the entries that
+// expired in the meantime, running their eviction callbacks with reason Expired.
+func (c *Cache) ResumeExpiry() {
+	c.expiryPaused.Store(false)
+	c.lock()
+	defer c.unlock()
+	c.removeExpired(nil)
+}
+
+// DrainExpired removes all expired entries and returns them in ascending order of expiry time.
+// The removal happens atomically under the lock; eviction callbacks run with reason Expired as
+// for any other expiry.
+func (c *Cache) DrainExpired() []CacheItemWithKey {
+	var drained []CacheItemWithKey
+	c.lock()
+	defer c.unlock()
+	c.removeExpired(func(kv *entry) {
+		drained = append(drained, CacheItemWithKey{Key: kv.key, CacheItem: kv.value})
+	})
+	return drained
+}
+
+// EvictOneExpired removes the expired entry with the earliest expiry time, the most overdue
+// one, and returns its key. It reports false if no entry has expired. Calling it repeatedly,
+// e.g. from a rate-limited background task, spreads the reclamation of memory over time
+// instead of removing all expired entries in one sweep. Each call scans the whole cache, and
+// the eviction callbacks run with reason Expired.
+func (c *Cache) EvictOneExpired() (key string, ok bool) {
+	c.lock()
+	defer c.unlock()
+	now := c.now()
+	var oldest *entry
+	for _, elem := range c.items {
+		kv, ok := c.entryOf(elem)
+		if !ok || !c.expired(kv.value, now) {
+			continue
+		}
+		if oldest == nil || kv.value.ExpiryTime.Before(oldest.value.ExpiryTime) {
+			oldest = kv
+		}
+	}
+	if oldest == nil {
+		return "", false
+	}
+	c.removeInExpiryOrder([]*entry{oldest}, now, nil)
+	return oldest.key, true
+}
+
+// Drain removes all entries from the cache in one step and returns the live ones, from the most
+// to the least recently used, e.g. to persist or hand them off on shutdown. Expired entries
+// are removed too but not returned. Once the lock has been released, the global eviction
+// callback is called with reason Drained for the returned entries and with reason Expired for
+// the others. Drained keys leave no tombstones, so ChangesSince does not report them as
+// deleted. Drain returns nil without removing anything if the cache is frozen.
+func (c *Cache) Drain() []CacheItemWithKey {
+	c.lock()
+	defer c.unlock()
+	if c.frozen.Load() {
+		return nil
+	}
+	now := c.now()
+	drained := make([]CacheItemWithKey, 0, len(c.items))
+	for elem := c.eviction.front(); elem != nil; {
+		next := c.eviction.next(elem)
+		if kv, ok := c.entryOf(elem); ok && !c.expired(kv.value, now) {
+			drained = append(drained, CacheItemWithKey{Key: kv.key, CacheItem: kv.value})
+			c.remove(elem, Drained)
+		} else {
+			c.remove(elem, Expired)
+		}
+		elem = next
+	}
+	return drained
+}
+
+// evictExpiredItems removes expired items from the cache. With WithSweepBudget it examines
+// at most the configured number of entries and continues where it left off on the next call,
+// otherwise it removes all expired items at once.
+func (c *Cache) evictExpiredItems() {
+	c.lock()
+	defer c.unlock()
+	c.pruneTombstones(c.now())
+	if c.sweepBudget <= 0 {
+		c.removeExpired(nil)
+		return
+	}
+
+	// Walk from the least recently used entry towards the front, resuming at the cursor.
+	now := c.now()
+	elem := c.sweepCursor
+	if elem == nil {
+		elem = c.eviction.back()
+	}
+	var expired []*entry
+	for n := 0; elem != nil && n < c.sweepBudget; n++ {
+		// A corrupted node holds no key to find its map entry by, so it is left for Repair
+		// rather than unlinked from the list alone.
+		if kv, ok := c.entryOf(elem); ok && c.expired(kv.value, now) {
+			expired = append(expired, kv)
+		}
+		elem = c.eviction.prev(elem)
+	}
+	c.sweepCursor = elem
+	c.removeInExpiryOrder(expired, now, nil)
+}
+
+// removeExpired removes all expired items from the cache and passes each of them to fn,
+// if it is not nil. The caller must hold the lock.
+func (c *Cache) removeExpired(fn func(kv *entry)) {
+	now := c.now()
+	var expired []*entry
+	for key, elem := range c.items {
+		kv, ok := c.entryOf(elem)
+		if !ok {
+			// Drop the corrupted node so it cannot linger in the cache.
+			c.eviction.remove(elem)
+			delete(c.items, key)
+			continue
+		}
+		if c.expired(kv.value, now) {
+			expired = append(expired, kv)
+		}
+	}
+	c.removeInExpiryOrder(expired, now, fn)
+}
+
+// removeInExpiryOrder removes the entries that have expired at now in ascending order of
+// expiry time, so the callbacks of a sweep run in the order the entries expired, and passes
+// each of them to fn, if it is not nil. It records how long the entries stayed in the cache
+// after expiring. The caller must hold the lock.
+func (c *Cache) removeInExpiryOrder(expired []*entry, now time.Time, fn func(kv *entry)) {
+	slices.SortStableFunc(expired, func(a, b *entry) int {
+		return a.value.ExpiryTime.Compare(b.value.ExpiryTime)
+	})
+	for _, kv := range expired {
+		c.expiryLag.record(now.Sub(kv.value.ExpiryTime))
+		c.remove(c.items[kv.key], Expired)
+		if fn != nil {
+			fn(kv)
 		}
 	}
 }
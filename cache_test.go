@@ -1,8 +1,21 @@
 package scache
 
 import (
+	"bytes"
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"math"
+	"math/rand"
+	"path"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -173,3 +186,2983 @@ func TestCacheConcurrency(t *testing.T) {
 
 	wg.Wait()
 }
+
+func TestCacheMerge(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ConflictPolicy
+		dstTTL time.Duration
+		srcTTL time.Duration
+		want   string
+	}{
+		{"keep existing", KeepExisting, 2 * time.Hour, 1 * time.Hour, testValue},
+		{"overwrite", Overwrite, 2 * time.Hour, 1 * time.Hour, "value2"},
+		{"keep newer expiry", KeepNewerExpiry, 1 * time.Hour, 2 * time.Hour, "value2"},
+		{"keep older when newer exists", KeepNewerExpiry, 2 * time.Hour, 1 * time.Hour, testValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := New(10)
+			src := New(10)
+			if err := dst.Set(testKey, testValue, tt.dstTTL); err != nil {
+				t.Errorf("Set() = %v, want %v", err, nil)
+			}
+			if err := src.Set(testKey, "value2", tt.srcTTL); err != nil {
+				t.Errorf("Set() = %v, want %v", err, nil)
+			}
+			if err := src.Set("key3", "value3", 1*time.Hour); err != nil {
+				t.Errorf("Set() = %v, want %v", err, nil)
+			}
+
+			dst.Merge(src, tt.policy)
+
+			value, err := dst.Get(testKey)
+			if err != nil || value != tt.want {
+				t.Errorf("Get() = %v, %v, want %v, %v", value, err, tt.want, nil)
+			}
+			if !dst.Contains("key3") {
+				t.Errorf("contains failed: the key %s should be exist", "key3")
+			}
+		})
+	}
+}
+
+func TestCacheMergeRespectsCapacity(t *testing.T) {
+	dst := New(2)
+	src := New(2)
+	if err := dst.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := src.Set("key2", "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := src.Set("key3", "value3", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	dst.Merge(src, Overwrite)
+
+	if dst.Contains(testKey) {
+		t.Errorf("contains failed: the key %s should not be exist", testKey)
+	}
+	if !dst.Contains("key2") || !dst.Contains("key3") {
+		t.Errorf("merge failed: merged keys should be exist")
+	}
+}
+
+func TestCacheMergeVersions(t *testing.T) {
+	dst := New(10)
+	src := New(10)
+	for i := 0; i < 5; i++ {
+		if err := dst.Set(testKey, testValue, 0); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if err := src.Set(testKey, "value2", 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	dst.Merge(src, Overwrite)
+
+	if value, version, err := dst.GetWithVersion(testKey); err != nil || value != "value2" || version != 6 {
+		t.Errorf("GetWithVersion() = %v, %v, %v, want %v, %v, %v", value, version, err, "value2", 6, nil)
+	}
+	if ok, err := dst.SetWithVersion(testKey, "stale", 1, 0); err != nil || ok {
+		t.Errorf("SetWithVersion() = %v, %v, want %v, %v", ok, err, false, nil)
+	}
+}
+
+func TestCacheSetErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		cache func() *Cache
+		key   string
+		value string
+		ttl   time.Duration
+		want  error
+	}{
+		{
+			name:  "closed",
+			cache: func() *Cache { c := New(10); _ = c.Close(); return c },
+			key:   testKey,
+			value: testValue,
+			ttl:   1 * time.Hour,
+			want:  ErrClosed,
+		},
+		{
+			name:  "closed and invalid ttl",
+			cache: func() *Cache { c := New(10); _ = c.Close(); return c },
+			key:   testKey,
+			value: testValue,
+			ttl:   -1 * time.Second,
+			want:  ErrClosed,
+		},
+		{
+			name:  "invalid ttl",
+			cache: func() *Cache { return New(10) },
+			key:   testKey,
+			value: testValue,
+			ttl:   -1 * time.Second,
+			want:  ErrInvalidTTL,
+		},
+		{
+			name:  "value too large",
+			cache: func() *Cache { return New(10, WithMaxValueSize(4)) },
+			key:   testKey,
+			value: testValue,
+			ttl:   1 * time.Hour,
+			want:  ErrValueTooLarge,
+		},
+		{
+			name: "cache full",
+			cache: func() *Cache {
+				c := New(1, WithRejectOnFull())
+				_ = c.Set("key2", "value2", 1*time.Hour)
+				return c
+			},
+			key:   testKey,
+			value: testValue,
+			ttl:   1 * time.Hour,
+			want:  ErrCacheFull,
+		},
+		{
+			name: "update when full",
+			cache: func() *Cache {
+				c := New(1, WithRejectOnFull())
+				_ = c.Set(testKey, "value2", 1*time.Hour)
+				return c
+			},
+			key:   testKey,
+			value: testValue,
+			ttl:   1 * time.Hour,
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cache().Set(tt.key, tt.value, tt.ttl); !errors.Is(err, tt.want) {
+				t.Errorf("Set() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheCompact(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Millisecond); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key2", "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key3", "value3", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	cache.Compact()
+
+	if len(cache.items) != 2 || cache.eviction.len() != 2 {
+		t.Errorf("Compact() left %d items and %d list nodes, want %d", len(cache.items), cache.eviction.len(), 2)
+	}
+	if front := frontKey(cache); front != "key3" {
+		t.Errorf("Compact() front = %v, want %v", front, "key3")
+	}
+	if !cache.Contains("key2") {
+		t.Errorf("contains failed: the key %s should be exist", "key2")
+	}
+}
+
+func TestCacheSwapContents(t *testing.T) {
+	var deleted []string
+	cache := New(2, WithOnEvict(func(key, _ string, reason EvictReason) {
+		if reason == Deleted {
+			deleted = append(deleted, key)
+		}
+	}))
+	if err := cache.Set("old", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	fresh := New(10)
+	for _, key := range []string{"a", "b", "c"} {
+		if err := fresh.Set(key, testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	cache.SwapContents(fresh)
+
+	if cache.Contains("old") || cache.Contains("a") {
+		t.Errorf("Keys() = %v, want %v", cache.Keys(), []string{"b", "c"})
+	}
+	if !cache.Contains("b") || !cache.Contains("c") {
+		t.Errorf("Keys() = %v, want %v", cache.Keys(), []string{"b", "c"})
+	}
+	if fresh.Len() != 0 {
+		t.Errorf("Len() = %v, want %v", fresh.Len(), 0)
+	}
+	if len(deleted) != 1 || deleted[0] != "old" {
+		t.Errorf("OnEvict deleted = %v, want %v", deleted, []string{"old"})
+	}
+	if err := cache.checkInvariants(); err != nil {
+		t.Errorf("checkInvariants() = %v, want %v", err, nil)
+	}
+	if err := fresh.Set("reused", testValue, 1*time.Hour); err != nil || !fresh.Contains("reused") {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+}
+
+func TestCacheSwapContentsFrozenMeanwhile(t *testing.T) {
+	for _, impl := range evictionLists {
+		t.Run(impl.name, func(t *testing.T) {
+			cache := New(10, impl.opts...)
+			if err := cache.Set("old", testValue, 1*time.Hour); err != nil {
+				t.Errorf("Set() = %v, want %v", err, nil)
+			}
+			// The hook runs once other has been emptied, before the cache is locked.
+			opts := append([]Option{WithEmptyTransitionHook(func(empty bool) {
+				if empty {
+					cache.Freeze()
+				}
+			})}, impl.opts...)
+			other := New(10, opts...)
+			for _, key := range []string{"a", "b"} {
+				if err := other.Set(key, testValue, 1*time.Hour); err != nil {
+					t.Errorf("Set() = %v, want %v", err, nil)
+				}
+			}
+
+			cache.SwapContents(other)
+
+			if keys := cache.Keys(); len(keys) != 1 || keys[0] != "old" {
+				t.Errorf("Keys() = %v, want %v", keys, []string{"old"})
+			}
+			if got := strings.Join(other.OrderedKeys(), ","); got != "b,a" {
+				t.Errorf("OrderedKeys() = %v, want %v", got, "b,a")
+			}
+			if err := other.checkInvariants(); err != nil {
+				t.Errorf("checkInvariants() = %v, want %v", err, nil)
+			}
+		})
+	}
+}
+
+func TestCacheAccessCountSaturates(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	_, kv, _ := cache.lookup(testKey)
+	kv.accessCount = math.MaxUint64 - 1
+
+	for i := 0; i < 3; i++ {
+		if _, err := cache.Get(testKey); err != nil {
+			t.Errorf("Get() = %v, want %v", err, nil)
+		}
+	}
+	if _, _, count, ok := cache.EntryInfo(testKey); !ok || count != math.MaxUint64 {
+		t.Errorf("EntryInfo() count = %v, want %v", count, uint64(math.MaxUint64))
+	}
+}
+
+func TestCacheMostRecent(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if err := cache.Set("expired", testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	now = now.Add(2 * time.Minute)
+	_, _ = cache.Get("a")
+	_, _ = cache.Peek("b")
+
+	if keys := cache.MostRecent(3); strings.Join(keys, ",") != "a,d,c" {
+		t.Errorf("MostRecent() = %v, want %v", keys, []string{"a", "d", "c"})
+	}
+	if keys := cache.MostRecent(10); len(keys) != 4 {
+		t.Errorf("MostRecent() = %v, want %v keys", keys, 4)
+	}
+	if keys := cache.MostRecent(0); len(keys) != 0 {
+		t.Errorf("MostRecent() = %v, want none", keys)
+	}
+	if keys := cache.MostRecent(3); strings.Join(keys, ",") != "a,d,c" {
+		t.Errorf("MostRecent() changed the order: %v", keys)
+	}
+}
+
+func TestCacheFreeze(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("short", testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	cache.Freeze()
+
+	if err := cache.Set("new", testValue, 1*time.Hour); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Set() = %v, want %v", err, ErrFrozen)
+	}
+	if err := cache.Persist(testKey); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Persist() = %v, want %v", err, ErrFrozen)
+	}
+	if err := cache.Flush(); !errors.Is(err, ErrFrozen) {
+		t.Errorf("Flush() = %v, want %v", err, ErrFrozen)
+	}
+	if cache.Delete(testKey) {
+		t.Errorf("Delete() = %v, want %v", true, false)
+	}
+	if value, err := cache.Get(testKey); err != nil || value != testValue {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := cache.Get("short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() = %v, want %v", err, ErrNotFound)
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %v, want %v", cache.Len(), 2)
+	}
+	cache.evictExpiredItems()
+	if cache.Len() != 1 {
+		t.Errorf("Len() = %v, want %v", cache.Len(), 1)
+	}
+}
+
+func TestCacheFreezeLeavesContentsUnchanged(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	for _, key := range []string{"a", "b"} {
+		if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if err := cache.Set("short", testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	cache.Freeze()
+	now = now.Add(2 * time.Minute)
+
+	if _, _, err := cache.GetWithVersion("a"); err != nil {
+		t.Errorf("GetWithVersion() = %v, want %v", err, nil)
+	}
+	if _, _, err := cache.GetWithMeta("short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetWithMeta() = %v, want %v", err, ErrNotFound)
+	}
+	if got := cache.GetMultiWithExpiry([]string{"a", "short"}); len(got) != 1 {
+		t.Errorf("GetMultiWithExpiry() = %v, want %v entry", got, 1)
+	}
+	if _, err := cache.GetCtx(context.Background(), "short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetCtx() = %v, want %v", err, ErrNotFound)
+	}
+	_, release, err := cache.GetRef("a")
+	if err != nil {
+		t.Fatalf("GetRef() = %v, want %v", err, nil)
+	}
+	release()
+	if got, want := strings.Join(cache.OrderedKeys(), ","), "b,a"; got != want {
+		t.Errorf("OrderedKeys() = %v, want %v", got, want)
+	}
+
+	other := New(10)
+	if err := other.Set("c", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	cache.Merge(other, Overwrite)
+	cache.SwapContents(other)
+	other.SwapContents(cache)
+	if cache.Len() != 3 {
+		t.Errorf("Len() = %v, want %v", cache.Len(), 3)
+	}
+	if other.Len() != 1 {
+		t.Errorf("Len() = %v, want %v", other.Len(), 1)
+	}
+}
+
+func TestCacheSortedEntries(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	for _, key := range []string{"c", "a", "b"} {
+		if err := cache.Set(key, "value-"+key, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if err := cache.Set("expired", testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	now = now.Add(2 * time.Minute)
+
+	want := []KV{{Key: "a", Value: "value-a"}, {Key: "b", Value: "value-b"}, {Key: "c", Value: "value-c"}}
+	got := cache.SortedEntries()
+	if len(got) != len(want) {
+		t.Fatalf("SortedEntries() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("SortedEntries() = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestCacheContainsAll(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	if err := cache.Set("a", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("expired", testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	now = now.Add(2 * time.Minute)
+
+	got := cache.ContainsAll([]string{"a", "missing", "expired", "a"})
+	want := []bool{true, false, false, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ContainsAll() = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestCacheDelete(t *testing.T) {
+	var reasons []EvictReason
+	cache := New(10, WithOnEvict(func(_, _ string, reason EvictReason) {
+		reasons = append(reasons, reason)
+	}))
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	if !cache.Delete(testKey) {
+		t.Errorf("Delete() = %v, want %v", false, true)
+	}
+	if cache.Delete(testKey) {
+		t.Errorf("Delete() = %v, want %v", true, false)
+	}
+	if cache.Contains(testKey) {
+		t.Errorf("contains failed: the key %s should not be exist", testKey)
+	}
+	if len(reasons) != 1 || reasons[0] != Deleted {
+		t.Errorf("OnEvict reasons = %v, want %v", reasons, []EvictReason{Deleted})
+	}
+}
+
+func TestCacheHealthCheck(t *testing.T) {
+	var evicted []string
+	cache := New(1, WithOnEvict(func(key, _ string, _ EvictReason) {
+		evicted = append(evicted, key)
+	}))
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	if err := cache.HealthCheck(); err != nil {
+		t.Errorf("HealthCheck() = %v, want %v", err, nil)
+	}
+	if !cache.Contains(testKey) || cache.Len() != 1 || len(evicted) != 0 {
+		t.Errorf("HealthCheck() disrupted the cache: Len() = %v, evicted %v", cache.Len(), evicted)
+	}
+
+	cache.eviction.pushBack(&entry{key: "orphan"})
+	if err := cache.HealthCheck(); err == nil {
+		t.Errorf("HealthCheck() = %v, want error", err)
+	}
+
+	closed := New(1)
+	_ = closed.Close()
+	if err := closed.HealthCheck(); !errors.Is(err, ErrClosed) {
+		t.Errorf("HealthCheck() = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestCacheWithMaxTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	cache := New(10,
+		WithClock(func() time.Time { return now }),
+		WithMaxTTL(1*time.Hour),
+		WithLogger(log.New(&buf, "", 0)),
+	)
+
+	tests := []struct {
+		key  string
+		ttl  time.Duration
+		want time.Duration
+	}{
+		{key: "short", ttl: 1 * time.Minute, want: 1 * time.Minute},
+		{key: "long", ttl: 30 * 24 * time.Hour, want: 1 * time.Hour},
+		{key: "forever", ttl: 0, want: 1 * time.Hour},
+	}
+	for _, tt := range tests {
+		if err := cache.Set(tt.key, testValue, tt.ttl); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+		if ttl, err := cache.TTL(tt.key); err != nil || ttl != tt.want {
+			t.Errorf("TTL(%q) = %v, %v, want %v, %v", tt.key, ttl, err, tt.want, nil)
+		}
+	}
+
+	if err := cache.Persist("short"); err != nil {
+		t.Errorf("Persist() = %v, want %v", err, nil)
+	}
+	if ttl, _ := cache.TTL("short"); ttl != 1*time.Hour {
+		t.Errorf("TTL() = %v, want %v", ttl, 1*time.Hour)
+	}
+	if strings.Count(buf.String(), "\n") != 3 {
+		t.Errorf("clamping logged %q, want one line per clamp", buf.String())
+	}
+}
+
+func TestCacheWithMaxTTLMerge(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }), WithMaxTTL(1*time.Hour))
+	other := New(10, WithClock(func() time.Time { return now }))
+	if err := other.Set("long", testValue, 30*24*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := other.Set("forever", testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	cache.Merge(other, Overwrite)
+	for _, key := range []string{"long", "forever"} {
+		if ttl, err := cache.TTL(key); err != nil || ttl != 1*time.Hour {
+			t.Errorf("TTL(%q) = %v, %v, want %v, %v", key, ttl, err, 1*time.Hour, nil)
+		}
+	}
+}
+
+func TestCacheSetWithMeta(t *testing.T) {
+	cache := New(10)
+	meta := map[string]string{"etag": "abc"}
+	if err := cache.SetWithMeta(testKey, testValue, meta, 1*time.Hour); err != nil {
+		t.Errorf("SetWithMeta() = %v, want %v", err, nil)
+	}
+	meta["etag"] = "changed"
+
+	value, got, err := cache.GetWithMeta(testKey)
+	if err != nil || value != testValue || got["etag"] != "abc" {
+		t.Errorf("GetWithMeta() = %v, %v, %v, want %v, %v, %v", value, got, err, testValue, map[string]string{"etag": "abc"}, nil)
+	}
+	got["etag"] = "changed"
+	if _, got, _ := cache.GetWithMeta(testKey); got["etag"] != "abc" {
+		t.Errorf("GetWithMeta() = %v, the returned map aliases the cached metadata", got)
+	}
+	if n := cache.ApproxBytes(); n != int64(len(testKey)+len(testValue)+len("etag")+len("abc")) {
+		t.Errorf("ApproxBytes() = %v, want %v", n, len(testKey)+len(testValue)+len("etag")+len("abc"))
+	}
+
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if _, got, err := cache.GetWithMeta(testKey); err != nil || got != nil {
+		t.Errorf("GetWithMeta() = %v, %v, want %v, %v", got, err, nil, nil)
+	}
+	if _, _, err := cache.GetWithMeta("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetWithMeta() = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCacheSetWithDeadline(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	deadline := now.Add(1 * time.Minute)
+	for _, key := range []string{"a", "b"} {
+		if err := cache.SetWithDeadline(key, testValue, deadline); err != nil {
+			t.Errorf("SetWithDeadline() = %v, want %v", err, nil)
+		}
+	}
+	if err := cache.SetWithDeadline("forever", testValue, time.Time{}); err != nil {
+		t.Errorf("SetWithDeadline() = %v, want %v", err, nil)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if item, err := cache.GetItem(key); err != nil || !item.ExpiryTime.Equal(deadline) {
+			t.Errorf("GetItem() = %v, %v, want expiry %v", item, err, deadline)
+		}
+	}
+	now = now.Add(2 * time.Minute)
+	if cache.Contains("a") {
+		t.Errorf("contains failed: the key %s should not be exist", "a")
+	}
+	if !cache.Contains("forever") {
+		t.Errorf("contains failed: the key %s should be exist", "forever")
+	}
+}
+
+func TestCacheSetUntilCtx(t *testing.T) {
+	cache := New(10)
+
+	deadline := time.Now().Add(1 * time.Hour)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := cache.SetUntilCtx(ctx, testKey, testValue); err != nil {
+		t.Errorf("SetUntilCtx() = %v, want %v", err, nil)
+	}
+	if item, err := cache.GetItem(testKey); err != nil || !item.ExpiryTime.Equal(deadline) {
+		t.Errorf("GetItem() = %v, %v, want expiry %v", item, err, deadline)
+	}
+
+	if err := cache.SetUntilCtx(context.Background(), "forever", testValue); err != nil {
+		t.Errorf("SetUntilCtx() = %v, want %v", err, nil)
+	}
+	if ttl, err := cache.TTL("forever"); err != nil || ttl != 0 {
+		t.Errorf("TTL() = %v, %v, want %v, %v", ttl, err, 0, nil)
+	}
+
+	cancelled, cancelNow := context.WithCancel(context.Background())
+	cancelNow()
+	if err := cache.SetUntilCtx(cancelled, "cancelled", testValue); !errors.Is(err, context.Canceled) {
+		t.Errorf("SetUntilCtx() = %v, want %v", err, context.Canceled)
+	}
+	if cache.Contains("cancelled") {
+		t.Errorf("contains failed: the key %s should not be exist", "cancelled")
+	}
+}
+
+func TestCacheReplacePrefix(t *testing.T) {
+	var deleted []string
+	cache := New(10, WithOnEvict(func(key, _ string, reason EvictReason) {
+		if reason == Deleted {
+			deleted = append(deleted, key)
+		}
+	}))
+	for _, key := range []string{"config:a", "config:b", "config:c", "other"} {
+		if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+
+	delta, err := cache.ReplacePrefix("config:", map[string]string{"a": "new-a", "d": "new-d"}, 1*time.Hour)
+	if err != nil || delta != -1 {
+		t.Errorf("ReplacePrefix() = %v, %v, want %v, %v", delta, err, -1, nil)
+	}
+	if value, _ := cache.Get("config:a"); value != "new-a" {
+		t.Errorf("Get() = %v, want %v", value, "new-a")
+	}
+	if cache.Contains("config:b") || !cache.Contains("config:d") || !cache.Contains("other") {
+		t.Errorf("Keys() = %v, want %v", cache.Keys(), []string{"config:a", "config:d", "other"})
+	}
+	if len(deleted) != 3 {
+		t.Errorf("OnEvict deleted = %v, want %v", deleted, []string{"config:a", "config:b", "config:c"})
+	}
+
+	if _, err := cache.ReplacePrefix("config:", map[string]string{"a": testValue}, -1); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("ReplacePrefix() = %v, want %v", err, ErrInvalidTTL)
+	}
+	if cache.Len() != 3 {
+		t.Errorf("Len() = %v, want %v", cache.Len(), 3)
+	}
+
+	full := New(2, WithRejectOnFull())
+	_ = full.Set("other", testValue, 1*time.Hour)
+	if _, err := full.ReplacePrefix("p:", map[string]string{"a": "1", "b": "2"}, 1*time.Hour); !errors.Is(err, ErrCacheFull) {
+		t.Errorf("ReplacePrefix() = %v, want %v", err, ErrCacheFull)
+	}
+	if full.Len() != 1 {
+		t.Errorf("Len() = %v, want %v", full.Len(), 1)
+	}
+}
+
+func TestCacheReplace(t *testing.T) {
+	cache := New(1, WithRejectOnFull())
+
+	old, existed, err := cache.Replace(testKey, "v1", 1*time.Hour)
+	if err != nil || existed || old != "" {
+		t.Errorf("Replace() = %q, %v, %v, want %q, %v, %v", old, existed, err, "", false, nil)
+	}
+	old, existed, err = cache.Replace(testKey, "v2", 1*time.Hour)
+	if err != nil || !existed || old != "v1" {
+		t.Errorf("Replace() = %q, %v, %v, want %q, %v, %v", old, existed, err, "v1", true, nil)
+	}
+	if value, _ := cache.Get(testKey); value != "v2" {
+		t.Errorf("Get() = %v, want %v", value, "v2")
+	}
+
+	if _, _, err := cache.Replace("other", testValue, 1*time.Hour); !errors.Is(err, ErrCacheFull) {
+		t.Errorf("Replace() = %v, want %v", err, ErrCacheFull)
+	}
+	if _, _, err := cache.Replace(testKey, testValue, -1); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("Replace() = %v, want %v", err, ErrInvalidTTL)
+	}
+
+	now := time.Now()
+	if err := cache.SetAt(testKey, "stale", 1*time.Second, now.Add(-1*time.Hour)); err != nil {
+		t.Errorf("SetAt() = %v, want %v", err, nil)
+	}
+	if _, existed, _ := cache.Replace(testKey, testValue, 1*time.Hour); existed {
+		t.Errorf("Replace() existed = %v, want %v", existed, false)
+	}
+}
+
+func TestCacheEvictionOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		ops  func(cache *Cache)
+		want []string
+	}{
+		{
+			name: "insertion order without accesses",
+			ops:  func(*Cache) {},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "get promotes",
+			ops: func(cache *Cache) {
+				_, _ = cache.Get("a")
+			},
+			want: []string{"b", "c", "a"},
+		},
+		{
+			name: "overwrite promotes",
+			ops: func(cache *Cache) {
+				_ = cache.Set("b", testValue, 1*time.Hour)
+			},
+			want: []string{"a", "c", "b"},
+		},
+		{
+			name: "peek and contains do not promote",
+			ops: func(cache *Cache) {
+				_, _ = cache.Peek("a")
+				_ = cache.Contains("a")
+			},
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "equal expiry times keep LRU order",
+			ops: func(cache *Cache) {
+				_, _ = cache.Get("b")
+				_, _ = cache.Get("a")
+			},
+			want: []string{"c", "b", "a"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			var evicted []string
+			cache := New(3,
+				WithClock(func() time.Time { return now }),
+				WithOnEvict(func(key, _ string, _ EvictReason) {
+					evicted = append(evicted, key)
+				}),
+			)
+			for _, key := range []string{"a", "b", "c"} {
+				if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+					t.Errorf("Set() = %v, want %v", err, nil)
+				}
+			}
+			tt.ops(cache)
+			for _, key := range []string{"x", "y", "z"} {
+				if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+					t.Errorf("Set() = %v, want %v", err, nil)
+				}
+			}
+			if strings.Join(evicted, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("evicted %v, want %v", evicted, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheEvictionIgnoresExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(2, WithClock(func() time.Time { return now }))
+	if err := cache.Set("a", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("b", testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	now = now.Add(2 * time.Minute)
+
+	if err := cache.Set("c", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if cache.Contains("a") {
+		t.Errorf("contains failed: the key %s should not be exist", "a")
+	}
+	if expired, present := cache.IsExpired("b"); !expired || !present {
+		t.Errorf("IsExpired() = %v, %v, want %v, %v", expired, present, true, true)
+	}
+}
+
+func TestCacheUsage(t *testing.T) {
+	cache := New(10)
+	if size, capacity := cache.Usage(); size != 0 || capacity != 10 {
+		t.Errorf("Usage() = %v, %v, want %v, %v", size, capacity, 0, 10)
+	}
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if size, capacity := cache.Usage(); size != 1 || capacity != 10 {
+		t.Errorf("Usage() = %v, %v, want %v, %v", size, capacity, 1, 10)
+	}
+}
+
+func TestCacheFlushFunc(t *testing.T) {
+	var deleted []string
+	cache := New(10, WithOnEvict(func(key, _ string, reason EvictReason) {
+		if reason == Deleted {
+			deleted = append(deleted, key)
+		}
+	}))
+	for _, key := range []string{"tenant1:a", "tenant1:b", "tenant2:a"} {
+		if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if err := cache.Set("forever", testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	removed := cache.FlushFunc(func(key, _ string, _ time.Time) bool {
+		return strings.HasPrefix(key, "tenant1:")
+	})
+	if removed != 2 || len(deleted) != 2 {
+		t.Errorf("FlushFunc() = %v, deleted %v, want %v", removed, deleted, 2)
+	}
+	removed = cache.FlushFunc(func(_, _ string, expiry time.Time) bool {
+		return expiry.IsZero()
+	})
+	if removed != 1 || cache.Contains("forever") {
+		t.Errorf("FlushFunc() = %v, want %v", removed, 1)
+	}
+	if !cache.Contains("tenant2:a") {
+		t.Errorf("contains failed: the key %s should be exist", "tenant2:a")
+	}
+}
+
+func TestCacheGetAndDelete(t *testing.T) {
+	var reasons []EvictReason
+	cache := New(10, WithOnEvict(func(_, _ string, reason EvictReason) {
+		reasons = append(reasons, reason)
+	}))
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	if value, err := cache.GetAndDelete(testKey); err != nil || value != testValue {
+		t.Errorf("GetAndDelete() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if _, err := cache.GetAndDelete(testKey); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetAndDelete() = %v, want %v", err, ErrNotFound)
+	}
+	if len(reasons) != 1 || reasons[0] != Deleted {
+		t.Errorf("OnEvict reasons = %v, want %v", reasons, []EvictReason{Deleted})
+	}
+
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	var wg sync.WaitGroup
+	var popped atomic.Int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.GetAndDelete(testKey); err == nil {
+				popped.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if popped.Load() != 1 {
+		t.Errorf("GetAndDelete() succeeded %d times, want %d", popped.Load(), 1)
+	}
+}
+
+func TestCacheDeleteMany(t *testing.T) {
+	var deleted []string
+	cache := New(10, WithOnEvict(func(key, _ string, reason EvictReason) {
+		if reason == Deleted {
+			deleted = append(deleted, key)
+		}
+	}))
+	for _, key := range []string{"a", "b", "c"} {
+		if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+
+	if removed := cache.DeleteMany([]string{"a", "c", "missing", "a"}); removed != 2 {
+		t.Errorf("DeleteMany() = %v, want %v", removed, 2)
+	}
+	if !cache.Contains("b") {
+		t.Errorf("contains failed: the key %s should be exist", "b")
+	}
+	if cache.Len() != 1 {
+		t.Errorf("Len() = %v, want %v", cache.Len(), 1)
+	}
+	if len(deleted) != 2 {
+		t.Errorf("OnEvict deleted = %v, want %v", deleted, []string{"a", "c"})
+	}
+}
+
+func TestCacheSetWithCallback(t *testing.T) {
+	var expired, evicted []string
+	cache := New(2, WithOnEvict(func(key, _ string, _ EvictReason) {
+		evicted = append(evicted, key)
+	}))
+	onExpire := func(key, _ string) {
+		expired = append(expired, key)
+	}
+
+	if err := cache.SetWithCallback(testKey, testValue, 1*time.Millisecond, onExpire); err != nil {
+		t.Errorf("SetWithCallback() = %v, want %v", err, nil)
+	}
+	if err := cache.SetWithCallback("key2", "value2", 1*time.Hour, onExpire); err != nil {
+		t.Errorf("SetWithCallback() = %v, want %v", err, nil)
+	}
+	if err := cache.SetWithCallback("key3", "value3", 1*time.Hour, onExpire); err != nil {
+		t.Errorf("SetWithCallback() = %v, want %v", err, nil)
+	}
+	time.Sleep(2 * time.Millisecond)
+	cache.evictExpiredItems()
+
+	// Overwritten and deleted entries drop their callback.
+	if err := cache.Set("key2", "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	cache.Delete("key3")
+
+	if len(expired) != 1 || expired[0] != testKey {
+		t.Errorf("onExpire keys = %v, want %v", expired, []string{testKey})
+	}
+	if len(evicted) != 2 {
+		t.Errorf("OnEvict keys = %v, want %d keys", evicted, 2)
+	}
+}
+
+func TestCacheEarlyExpiration(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }), WithEarlyExpiration(1))
+	draws := []float64{0.9, 0.9, 0.9, 0.1, 0.1, 0.1}
+	cache.random = func() float64 {
+		r := draws[0]
+		draws = draws[1:]
+		return r
+	}
+	loads := 0
+	loader := func() (string, error) {
+		loads++
+		now = now.Add(10 * time.Second) // The recomputation takes 10s.
+		return testValue, nil
+	}
+
+	if _, err := cache.GetOrSet(testKey, 1*time.Minute, loader); err != nil {
+		t.Errorf("GetOrSet() = %v, want %v", err, nil)
+	}
+
+	// 55s later 5s remain: a caller refreshes only if 10s·-ln(rand) >= 5s, i.e. rand <= 0.61.
+	now = now.Add(55 * time.Second)
+	for i := 0; i < 6; i++ {
+		if value, err := cache.GetOrSet(testKey, 1*time.Minute, loader); err != nil || value != testValue {
+			t.Errorf("GetOrSet() = %v, %v, want %v, %v", value, err, testValue, nil)
+		}
+	}
+	// The first three callers keep the cached value, the fourth refreshes it and the last
+	// two see the fresh entry, which is far from expiry.
+	if loads != 2 {
+		t.Errorf("loader called %d times, want %d", loads, 2)
+	}
+	if len(draws) != 0 {
+		t.Errorf("%d draws left, want %d", len(draws), 0)
+	}
+}
+
+func TestCacheEarlyExpirationKeepsValueOnError(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }), WithEarlyExpiration(1))
+	cache.random = func() float64 { return 0.1 }
+	if _, err := cache.GetOrSet(testKey, 1*time.Minute, func() (string, error) {
+		now = now.Add(10 * time.Second)
+		return testValue, nil
+	}); err != nil {
+		t.Errorf("GetOrSet() = %v, want %v", err, nil)
+	}
+
+	// 5s before expiry every caller refreshes early, but the loader fails.
+	now = now.Add(55 * time.Second)
+	errUpstream := errors.New("upstream unavailable")
+	loaders := []func() (string, error){
+		func() (string, error) { return "", errUpstream },
+		func() (string, error) { panic("upstream unavailable") },
+	}
+	for _, loader := range loaders {
+		if value, err := cache.GetOrSet(testKey, 1*time.Minute, loader); err != nil || value != testValue {
+			t.Errorf("GetOrSet() = %v, %v, want %v, %v", value, err, testValue, nil)
+		}
+	}
+
+	// Once the entry has expired, the error is returned.
+	now = now.Add(10 * time.Second)
+	if _, err := cache.GetOrSet(testKey, 1*time.Minute, loaders[0]); !errors.Is(err, errUpstream) {
+		t.Errorf("GetOrSet() = %v, want %v", err, errUpstream)
+	}
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	tests := []struct {
+		key    string
+		ttl    time.Duration
+		cached bool
+		want   time.Duration
+	}{
+		{key: "fresh", ttl: 5 * time.Minute, cached: true, want: 5 * time.Minute},
+		{key: "forever", ttl: 0, cached: true, want: 0},
+		{key: "uncacheable", ttl: -1, cached: false},
+	}
+	for _, tt := range tests {
+		value, err := cache.GetOrLoad(tt.key, func() (string, time.Duration, error) {
+			return testValue, tt.ttl, nil
+		})
+		if err != nil || value != testValue {
+			t.Errorf("GetOrLoad(%q) = %v, %v, want %v, %v", tt.key, value, err, testValue, nil)
+		}
+		if cache.Contains(tt.key) != tt.cached {
+			t.Errorf("Contains(%q) = %v, want %v", tt.key, !tt.cached, tt.cached)
+		}
+		if ttl, err := cache.TTL(tt.key); tt.cached && (err != nil || ttl != tt.want) {
+			t.Errorf("TTL(%q) = %v, %v, want %v, %v", tt.key, ttl, err, tt.want, nil)
+		}
+	}
+
+	loadErr := errors.New("upstream failed")
+	_, err := cache.GetOrLoad("failing", func() (string, time.Duration, error) {
+		return "", 1 * time.Minute, loadErr
+	})
+	if !errors.Is(err, loadErr) || cache.Contains("failing") {
+		t.Errorf("GetOrLoad() = %v, want %v", err, loadErr)
+	}
+	if _, err := cache.GetOrSet("negative", -1, func() (string, error) { return testValue, nil }); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("GetOrSet() = %v, want %v", err, ErrInvalidTTL)
+	}
+}
+
+func TestCacheGetOrSet(t *testing.T) {
+	cache := New(10)
+	var calls atomic.Int32
+	loader := func() (string, error) {
+		calls.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return testValue, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := cache.GetOrSet(testKey, 1*time.Hour, loader)
+			if err != nil || value != testValue {
+				t.Errorf("GetOrSet() = %v, %v, want %v, %v", value, err, testValue, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("loader called %d times, want %d", n, 1)
+	}
+	if !cache.Contains(testKey) {
+		t.Errorf("contains failed: the key %s should be exist", testKey)
+	}
+}
+
+func TestCacheGetOrSetLoaderPanic(t *testing.T) {
+	cache := New(10)
+	started := make(chan struct{})
+	var once sync.Once
+	loader := func() (string, error) {
+		once.Do(func() { close(started) })
+		time.Sleep(10 * time.Millisecond)
+		panic("boom")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, err := cache.GetOrSet(testKey, 1*time.Hour, loader); !errors.Is(err, ErrLoaderPanic) {
+			t.Errorf("GetOrSet() = %v, want %v", err, ErrLoaderPanic)
+		}
+	}()
+	<-started
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.GetOrSet(testKey, 1*time.Hour, loader); !errors.Is(err, ErrLoaderPanic) {
+				t.Errorf("GetOrSet() = %v, want %v", err, ErrLoaderPanic)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cache.Contains(testKey) {
+		t.Errorf("contains failed: the key %s should not be exist", testKey)
+	}
+}
+
+func TestCacheInvariants(t *testing.T) {
+	cache := New(3)
+	for i := 0; i < 10; i++ {
+		key := strconv.Itoa(i % 5)
+		if err := cache.Set(key, "value"+key, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+		_, _ = cache.Get(strconv.Itoa(i % 3))
+		if i%4 == 0 {
+			cache.Delete(key)
+		}
+	}
+	cache.Merge(New(2), Overwrite)
+	cache.Compact()
+
+	if err := cache.checkInvariants(); err != nil {
+		t.Errorf("checkInvariants() = %v, want %v", err, nil)
+	}
+
+	// An orphaned list node must be detected.
+	cache.eviction.pushBack(&entry{key: "orphan"})
+	if err := cache.checkInvariants(); err == nil {
+		t.Errorf("checkInvariants() = %v, want error", err)
+	}
+}
+
+func TestCacheAsyncWrites(t *testing.T) {
+	cache := New(100, WithAsyncWrites(100))
+	for i := 0; i < 50; i++ {
+		key := strconv.Itoa(i)
+		if err := cache.Set(key, "value"+key, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Errorf("Close() = %v, want %v", err, nil)
+	}
+
+	for i := 0; i < 50; i++ {
+		key := strconv.Itoa(i)
+		value, err := cache.Get(key)
+		if err != nil || value != "value"+key {
+			t.Errorf("Get() = %v, %v, want %v, %v", value, err, "value"+key, nil)
+		}
+	}
+	if err := cache.Set(testKey, testValue, 1*time.Hour); !errors.Is(err, ErrClosed) {
+		t.Errorf("Set() = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestCacheKeys(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key2", "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key3", "value3", 1*time.Millisecond); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	keys := cache.Keys()
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "key2" || keys[1] != testKey {
+		t.Errorf("Keys() = %v, want %v", keys, []string{"key2", testKey})
+	}
+}
+
+func TestCacheKeyNormalizer(t *testing.T) {
+	cache := New(10, WithKeyNormalizer(strings.ToLower))
+	if err := cache.Set("ETag", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	value, err := cache.Get("etag")
+	if err != nil || value != testValue {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if !cache.Contains("ETAG") {
+		t.Errorf("contains failed: the key %s should be exist", "ETAG")
+	}
+	if keys := cache.Keys(); len(keys) != 1 || keys[0] != "etag" {
+		t.Errorf("Keys() = %v, want %v", keys, []string{"etag"})
+	}
+	if !cache.Delete("Etag") {
+		t.Errorf("Delete() = %v, want %v", false, true)
+	}
+}
+
+func TestCacheKeyNormalizedOnce(t *testing.T) {
+	cache := New(10, WithKeyNormalizer(func(key string) string { return "ns:" + key }))
+	calls := 0
+	for i := 0; i < 3; i++ {
+		value, err := cache.GetOrSet(testKey, 1*time.Hour, func() (string, error) {
+			calls++
+			return testValue, nil
+		})
+		if err != nil || value != testValue {
+			t.Errorf("GetOrSet() = %v, %v, want %v, %v", value, err, testValue, nil)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("loader calls = %v, want %v", calls, 1)
+	}
+	values, err := cache.GetOrSetMulti([]string{testKey}, 1*time.Hour, func(missing []string) (map[string]string, error) {
+		t.Errorf("loader called for %v, want no call", missing)
+		return nil, nil
+	})
+	if err != nil || values[testKey] != testValue {
+		t.Errorf("GetOrSetMulti() = %v, %v, want %v, %v", values, err, testValue, nil)
+	}
+
+	if _, err := cache.ReplacePrefix("p:", map[string]string{"a": "1"}, 0); err != nil {
+		t.Errorf("ReplacePrefix() = %v, want %v", err, nil)
+	}
+	ns := cache.Namespace("p:")
+	if value, err := ns.Get("a"); err != nil || value != "1" {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, "1", nil)
+	}
+	if keys := ns.Keys(); len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("Keys() = %v, want %v", keys, []string{"a"})
+	}
+}
+
+func TestCacheGetMultiWithExpiry(t *testing.T) {
+	cache := New(3)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key2", "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key3", "value3", 1*time.Millisecond); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	keys := []string{testKey, "key3", "missing"}
+	peeked := cache.PeekMultiWithExpiry(keys)
+	if len(peeked) != 1 || peeked[testKey].Value != testValue {
+		t.Errorf("PeekMultiWithExpiry() = %v, want only %v", peeked, testKey)
+	}
+	if back := backKey(cache); back != testKey {
+		t.Errorf("PeekMultiWithExpiry() promoted entries, LRU = %v, want %v", back, testKey)
+	}
+
+	items := cache.GetMultiWithExpiry(keys)
+	if len(items) != 1 || items[testKey].Value != testValue || items[testKey].ExpiryTime.IsZero() {
+		t.Errorf("GetMultiWithExpiry() = %v, want only %v", items, testKey)
+	}
+	if back := backKey(cache); back != "key2" {
+		t.Errorf("GetMultiWithExpiry() LRU = %v, want %v", back, "key2")
+	}
+}
+
+func TestCacheDeleteMatch(t *testing.T) {
+	var deleted []string
+	cache := New(10, WithOnEvict(func(key, _ string, _ EvictReason) {
+		deleted = append(deleted, key)
+	}))
+	for _, key := range []string{"session:1:temp", "session:2:temp", "session:1:data"} {
+		if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+
+	n, err := cache.DeleteMatch("session:*:temp")
+	if err != nil || n != 2 {
+		t.Errorf("DeleteMatch() = %v, %v, want %v, %v", n, err, 2, nil)
+	}
+	if !cache.Contains("session:1:data") {
+		t.Errorf("contains failed: the key %s should be exist", "session:1:data")
+	}
+	if len(deleted) != 2 {
+		t.Errorf("OnEvict keys = %v, want %d keys", deleted, 2)
+	}
+
+	if _, err := cache.DeleteMatch("session:["); !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("DeleteMatch() = %v, want %v", err, path.ErrBadPattern)
+	}
+}
+
+func TestCachePeek(t *testing.T) {
+	cache := New(2)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key2", "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	value, err := cache.Peek(testKey)
+	if err != nil || value != testValue {
+		t.Errorf("Peek() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+
+	// Peek must not promote, so testKey is still the LRU entry.
+	if err := cache.Set("key3", "value3", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if _, err := cache.Peek(testKey); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Peek() = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCacheTTLAndLen(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	ttl, err := cache.TTL(testKey)
+	if err != nil || ttl <= 59*time.Minute || ttl > 1*time.Hour {
+		t.Errorf("TTL() = %v, %v, want about %v, %v", ttl, err, 1*time.Hour, nil)
+	}
+	if _, err := cache.TTL("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("TTL() = %v, want %v", err, ErrNotFound)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Len() = %v, want %v", n, 1)
+	}
+}
+
+func TestCachePromotionThreshold(t *testing.T) {
+	cache := New(2, WithPromotionThreshold(2))
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key2", "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	_, _ = cache.Get(testKey)
+	if back := backKey(cache); back != testKey {
+		t.Errorf("Get() promoted before threshold, LRU = %v, want %v", back, testKey)
+	}
+	_, _ = cache.Get(testKey)
+	if back := backKey(cache); back != "key2" {
+		t.Errorf("Get() did not promote at threshold, LRU = %v, want %v", back, "key2")
+	}
+}
+
+func benchmarkGetZipf(b *testing.B, threshold int) {
+	const numKeys = 10000
+	cache := New(numKeys, WithPromotionThreshold(threshold))
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		_ = cache.Set(keys[i], "value", 1*time.Hour)
+	}
+	zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.1, 1, numKeys-1)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = cache.Get(keys[zipf.Uint64()])
+	}
+}
+
+func BenchmarkGetZipf(b *testing.B) {
+	benchmarkGetZipf(b, 1)
+}
+
+func BenchmarkGetZipfPromotionThreshold(b *testing.B) {
+	benchmarkGetZipf(b, 8)
+}
+
+func TestCacheExpireAndPersist(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	if err := cache.Expire(testKey, time.Now().Add(1*time.Millisecond)); err != nil {
+		t.Errorf("Expire() = %v, want %v", err, nil)
+	}
+	if err := cache.Persist(testKey); err != nil {
+		t.Errorf("Persist() = %v, want %v", err, nil)
+	}
+	time.Sleep(2 * time.Millisecond)
+	cache.evictExpiredItems()
+
+	value, err := cache.Get(testKey)
+	if err != nil || value != testValue {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if ttl, err := cache.TTL(testKey); err != nil || ttl != 0 {
+		t.Errorf("TTL() = %v, %v, want %v, %v", ttl, err, 0, nil)
+	}
+
+	if err := cache.Expire(testKey, time.Now().Add(-1*time.Second)); err != nil {
+		t.Errorf("Expire() = %v, want %v", err, nil)
+	}
+	if cache.Contains(testKey) {
+		t.Errorf("contains failed: the key %s should not be exist", testKey)
+	}
+	if err := cache.Persist(testKey); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Persist() = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCacheSetItemAndGetItem(t *testing.T) {
+	src := New(10)
+	dst := New(10)
+	if err := src.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	item, err := src.GetItem(testKey)
+	if err != nil || item.Value != testValue {
+		t.Errorf("GetItem() = %v, %v, want %v, %v", item, err, testValue, nil)
+	}
+	if err := dst.SetItem(testKey, item); err != nil {
+		t.Errorf("SetItem() = %v, want %v", err, nil)
+	}
+
+	copied, err := dst.GetItem(testKey)
+	if err != nil || copied != item {
+		t.Errorf("GetItem() = %v, %v, want %v, %v", copied, err, item, nil)
+	}
+
+	expired := CacheItem{Value: testValue, ExpiryTime: time.Now().Add(-1 * time.Second)}
+	if err := dst.SetItem("key2", expired); err != nil {
+		t.Errorf("SetItem() = %v, want %v", err, nil)
+	}
+	if _, err := dst.GetItem("key2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetItem() = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCacheSetHugeTTL(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, time.Duration(math.MaxInt64)); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key2", "value2", 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	cache.evictExpiredItems()
+
+	for _, key := range []string{testKey, "key2"} {
+		if _, err := cache.Get(key); err != nil {
+			t.Errorf("Get() = %v, want %v", err, nil)
+		}
+	}
+}
+
+func TestCacheForEach(t *testing.T) {
+	cache := New(10)
+	for i := 0; i < 5; i++ {
+		key := strconv.Itoa(i)
+		if err := cache.Set(key, "value"+key, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+
+	seen := make(map[string]string)
+	cache.ForEach(func(key, value string) bool {
+		seen[key] = value
+		return true
+	})
+	if len(seen) != 5 || seen["3"] != "value3" {
+		t.Errorf("ForEach() visited %v, want %d entries", seen, 5)
+	}
+
+	visited := 0
+	cache.ForEach(func(string, string) bool {
+		visited++
+		return false
+	})
+	if visited != 1 {
+		t.Errorf("ForEach() visited %d entries after stop, want %d", visited, 1)
+	}
+}
+
+func TestCacheForEachReentrantFlush(t *testing.T) {
+	cache := New(10)
+	for i := 0; i < 5; i++ {
+		key := strconv.Itoa(i)
+		if err := cache.Set(key, "value"+key, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+
+	visited := 0
+	cache.ForEach(func(string, string) bool {
+		visited++
+		if err := cache.Flush(); err != nil {
+			t.Errorf("flush failed: expected nil, got %v", err)
+		}
+		return true
+	})
+
+	if visited != 5 {
+		t.Errorf("ForEach() visited %d entries, want the %d entries of the snapshot", visited, 5)
+	}
+	if n := cache.Len(); n != 0 {
+		t.Errorf("Len() = %v, want %v", n, 0)
+	}
+}
+
+func TestCacheForEachConcurrentFlush(t *testing.T) {
+	cache := New(100)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 1000; i++ {
+			_ = cache.Set(strconv.Itoa(i%100), testValue, 1*time.Hour)
+			if i%10 == 0 {
+				_ = cache.Flush()
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			cache.ForEach(func(_, value string) bool {
+				if value != testValue {
+					t.Errorf("ForEach() value = %v, want %v", value, testValue)
+				}
+				return true
+			})
+		}
+	}()
+	wg.Wait()
+}
+
+func TestCacheApproxBytes(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key2", "value2", 1*time.Millisecond); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	want := int64(len(testKey) + len(testValue))
+	if n := cache.ApproxBytes(); n != want {
+		t.Errorf("ApproxBytes() = %v, want %v", n, want)
+	}
+}
+
+func TestCacheIsExpired(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Millisecond); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if expired, present := cache.IsExpired(testKey); expired || !present {
+		t.Errorf("IsExpired() = %v, %v, want %v, %v", expired, present, false, true)
+	}
+
+	time.Sleep(2 * time.Millisecond)
+	if expired, present := cache.IsExpired(testKey); !expired || !present {
+		t.Errorf("IsExpired() = %v, %v, want %v, %v", expired, present, true, true)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Len() = %v, want %v", n, 1)
+	}
+	if expired, present := cache.IsExpired("missing"); expired || present {
+		t.Errorf("IsExpired() = %v, %v, want %v, %v", expired, present, false, false)
+	}
+}
+
+func TestCacheEvictionBatchSize(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reasons := make(map[string]EvictReason)
+	cache := New(4,
+		WithClock(func() time.Time { return now }),
+		WithEvictionBatchSize(3),
+		WithOnEvict(func(key, _ string, reason EvictReason) { reasons[key] = reason }),
+	)
+	for i, ttl := range []time.Duration{1 * time.Minute, 1 * time.Minute, 1 * time.Hour, 1 * time.Hour} {
+		if err := cache.Set(strconv.Itoa(i), testValue, ttl); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	now = now.Add(2 * time.Minute)
+
+	// Both expired entries are removed at once, so the next insert finds room as well.
+	for _, key := range []string{"4", "5"} {
+		if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	want := map[string]EvictReason{"0": Expired, "1": Expired}
+	if !maps.Equal(reasons, want) {
+		t.Errorf("OnEvict reasons = %v, want %v", reasons, want)
+	}
+
+	// Without expired entries, only a single live entry is evicted.
+	if err := cache.Set("6", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if n := cache.Len(); n != 4 {
+		t.Errorf("Len() = %v, want %v", n, 4)
+	}
+	if reasons["2"] != Evicted || cache.Contains("2") {
+		t.Errorf("OnEvict reasons = %v, want %v evicted", reasons, "2")
+	}
+}
+
+func benchmarkSetAfterMassExpiry(b *testing.B, batchSize int) {
+	const numKeys = 10000
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(numKeys, WithClock(func() time.Time { return now }), WithEvictionBatchSize(batchSize))
+	keys := make([]string, 2*numKeys)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if i%numKeys == 0 {
+			// All entries stored so far expire at once.
+			now = now.Add(2 * time.Hour)
+		}
+		_ = cache.Set(keys[i%len(keys)], "value", 1*time.Hour)
+	}
+}
+
+func BenchmarkSetAfterMassExpiry(b *testing.B) {
+	benchmarkSetAfterMassExpiry(b, 1)
+}
+
+func BenchmarkSetAfterMassExpiryBatched(b *testing.B) {
+	benchmarkSetAfterMassExpiry(b, 64)
+}
+
+func benchmarkSetAtCapacity(b *testing.B, batchSize int) {
+	const numKeys = 10000
+	cache := New(numKeys, WithEvictionBatchSize(batchSize))
+	keys := make([]string, 4*numKeys)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = cache.Set(keys[i%len(keys)], "value", 1*time.Hour)
+	}
+}
+
+func BenchmarkSetAtCapacity(b *testing.B) {
+	benchmarkSetAtCapacity(b, 1)
+}
+
+func BenchmarkSetAtCapacityBatched(b *testing.B) {
+	benchmarkSetAtCapacity(b, 64)
+}
+
+func benchmarkColdStart(b *testing.B, opts ...Option) {
+	const numKeys = 10000
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cache := New(numKeys, opts...)
+		for _, key := range keys {
+			_ = cache.Set(key, "value", 1*time.Hour)
+		}
+	}
+}
+
+func BenchmarkColdStart(b *testing.B) {
+	benchmarkColdStart(b)
+}
+
+func BenchmarkColdStartWithCapacityHint(b *testing.B) {
+	benchmarkColdStart(b, WithInitialCapacityHint(10000))
+}
+
+func TestCacheValueIndex(t *testing.T) {
+	cache := New(2, WithValueIndex())
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	key, ok := cache.KeyForValue(testValue)
+	if !ok || key != testKey {
+		t.Errorf("KeyForValue() = %v, %v, want %v, %v", key, ok, testKey, true)
+	}
+
+	// Overwriting the key drops the old value from the index.
+	if err := cache.Set(testKey, "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if _, ok := cache.KeyForValue(testValue); ok {
+		t.Errorf("KeyForValue() = %v, want %v", ok, false)
+	}
+
+	// Eviction and deletion keep the index in sync.
+	if err := cache.Set("key3", "value3", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key4", "value4", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if _, ok := cache.KeyForValue("value2"); ok {
+		t.Errorf("KeyForValue() = %v, want %v", ok, false)
+	}
+	cache.Delete("key3")
+	if _, ok := cache.KeyForValue("value3"); ok {
+		t.Errorf("KeyForValue() = %v, want %v", ok, false)
+	}
+	if key, ok := cache.KeyForValue("value4"); !ok || key != "key4" {
+		t.Errorf("KeyForValue() = %v, %v, want %v, %v", key, ok, "key4", true)
+	}
+	if len(cache.valueIndex) != cache.Len() {
+		t.Errorf("value index has %d entries, want %d", len(cache.valueIndex), cache.Len())
+	}
+}
+
+func TestCacheRepair(t *testing.T) {
+	var buf bytes.Buffer
+	cache := New(10, WithLogger(log.New(&buf, "", 0)), WithValueIndex())
+	for _, key := range []string{"a", "b", "c"} {
+		if err := cache.Set(key, key, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if removed := cache.Repair(); removed != 0 {
+		t.Errorf("Repair() = %v, want %v", removed, 0)
+	}
+
+	cache.eviction.(*containerList).l.PushBack("not an entry")
+	cache.eviction.pushBack(&entry{key: "a", value: CacheItem{Value: "a"}})
+	cache.items["orphan"] = &list.Element{Value: &entry{key: "orphan"}}
+	cache.eviction.remove(cache.items["b"])
+
+	if removed := cache.Repair(); removed != 4 {
+		t.Errorf("Repair() = %v, want %v", removed, 4)
+	}
+	if err := cache.checkInvariants(); err != nil {
+		t.Errorf("checkInvariants() = %v, want %v", err, nil)
+	}
+	if err := cache.HealthCheck(); err != nil {
+		t.Errorf("HealthCheck() = %v, want %v", err, nil)
+	}
+	if !cache.Contains("a") || !cache.Contains("c") || cache.Len() != 2 {
+		t.Errorf("Keys() = %v, want %v", cache.Keys(), []string{"a", "c"})
+	}
+	if key, ok := cache.KeyForValue("a"); !ok || key != "a" {
+		t.Errorf("KeyForValue() = %v, %v, want %v, %v", key, ok, "a", true)
+	}
+	if strings.Count(buf.String(), "\n") != 4 {
+		t.Errorf("Repair() logged %q, want one line per repair", buf.String())
+	}
+}
+
+func TestCacheCorruptedNode(t *testing.T) {
+	var buf bytes.Buffer
+	cache := New(10, WithLogger(log.New(&buf, "", 0)))
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	cache.items["bad"] = cache.eviction.(*containerList).l.PushFront("not an entry")
+
+	if _, err := cache.Get("bad"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := cache.Peek("bad"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Peek() = %v, want %v", err, ErrNotFound)
+	}
+	if keys := cache.Keys(); len(keys) != 1 {
+		t.Errorf("Keys() = %v, want %v", keys, []string{testKey})
+	}
+	if buf.Len() == 0 {
+		t.Errorf("corrupted node was not logged")
+	}
+
+	cache.evictExpiredItems()
+	if err := cache.checkInvariants(); err != nil {
+		t.Errorf("checkInvariants() = %v, want %v", err, nil)
+	}
+	if value, err := cache.Get(testKey); err != nil || value != testValue {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+}
+
+func TestCacheCorruptedNodeWithSweepBudget(t *testing.T) {
+	cache := New(10, WithLogger(log.New(&bytes.Buffer{}, "", 0)), WithSweepBudget(2))
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	cache.items["bad"] = cache.eviction.(*containerList).l.PushFront("not an entry")
+
+	cache.evictExpiredItems()
+	if err := cache.HealthCheck(); err != nil {
+		t.Errorf("HealthCheck() = %v, want %v", err, nil)
+	}
+	if removed := cache.Repair(); removed != 2 {
+		t.Errorf("Repair() = %v, want %v", removed, 2)
+	}
+	if err := cache.checkInvariants(); err != nil {
+		t.Errorf("checkInvariants() = %v, want %v", err, nil)
+	}
+}
+
+func TestCacheGetOrSetDistinctKeysInParallel(t *testing.T) {
+	cache := New(10)
+	startedA := make(chan struct{})
+	startedB := make(chan struct{})
+	wait := func(ch chan struct{}) error {
+		select {
+		case <-ch:
+			return nil
+		case <-time.After(1 * time.Second):
+			return errors.New("loaders did not run in parallel")
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, err := cache.GetOrSet("keyA", 1*time.Hour, func() (string, error) {
+			close(startedA)
+			return "valueA", wait(startedB)
+		})
+		if err != nil {
+			t.Errorf("GetOrSet() = %v, want %v", err, nil)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		_, err := cache.GetOrSet("keyB", 1*time.Hour, func() (string, error) {
+			close(startedB)
+			return "valueB", wait(startedA)
+		})
+		if err != nil {
+			t.Errorf("GetOrSet() = %v, want %v", err, nil)
+		}
+	}()
+	wg.Wait()
+}
+
+func TestCacheMaxConcurrentLoads(t *testing.T) {
+	const limit = 3
+	cache := New(100, WithMaxConcurrentLoads(limit))
+	var running, peak atomic.Int32
+	loader := func() (string, error) {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+		return testValue, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.GetOrSet(strconv.Itoa(i), 1*time.Hour, loader); err != nil {
+				t.Errorf("GetOrSet() = %v, want %v", err, nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if p := peak.Load(); p > limit || p == 0 {
+		t.Errorf("concurrent loads = %v, want at most %v", p, limit)
+	}
+	if n := cache.Len(); n != 20 {
+		t.Errorf("Len() = %v, want %v", n, 20)
+	}
+}
+
+func TestCacheGetOrSetMulti(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set("a", "cached", 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	var asked []string
+	loader := func(missing []string) (map[string]string, error) {
+		asked = append(asked, missing...)
+		values := make(map[string]string)
+		for _, key := range missing {
+			if key != "unknown" {
+				values[key] = "loaded-" + key
+			}
+		}
+		return values, nil
+	}
+
+	got, err := cache.GetOrSetMulti([]string{"a", "b", "c", "b", "unknown"}, 1*time.Hour, loader)
+	if err != nil {
+		t.Errorf("GetOrSetMulti() = %v, want %v", err, nil)
+	}
+	want := map[string]string{"a": "cached", "b": "loaded-b", "c": "loaded-c"}
+	if !maps.Equal(got, want) {
+		t.Errorf("GetOrSetMulti() = %v, want %v", got, want)
+	}
+	if strings.Join(asked, ",") != "b,c,unknown" {
+		t.Errorf("loader keys = %v, want %v", asked, []string{"b", "c", "unknown"})
+	}
+	if value, err := cache.Get("c"); err != nil || value != "loaded-c" {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, "loaded-c", nil)
+	}
+
+	failing := func([]string) (map[string]string, error) {
+		return nil, errors.New("backend down")
+	}
+	if got, err := cache.GetOrSetMulti([]string{"a", "d"}, 1*time.Hour, failing); err == nil || got["a"] != "cached" {
+		t.Errorf("GetOrSetMulti() = %v, %v, want partial result and error", got, err)
+	}
+	if cache.Contains("d") {
+		t.Errorf("contains failed: the key %s should not be exist", "d")
+	}
+}
+
+func TestCacheGetOrSetMultiSharesLoads(t *testing.T) {
+	cache := New(10)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = cache.GetOrSet("a", 1*time.Hour, func() (string, error) {
+			close(started)
+			<-release
+			return "single", nil
+		})
+	}()
+	<-started
+
+	var asked []string
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		close(release)
+	}()
+	got, err := cache.GetOrSetMulti([]string{"a", "b"}, 1*time.Hour, func(missing []string) (map[string]string, error) {
+		asked = append(asked, missing...)
+		return map[string]string{"b": "batch"}, nil
+	})
+	<-done
+	if err != nil {
+		t.Errorf("GetOrSetMulti() = %v, want %v", err, nil)
+	}
+	if want := map[string]string{"a": "single", "b": "batch"}; !maps.Equal(got, want) {
+		t.Errorf("GetOrSetMulti() = %v, want %v", got, want)
+	}
+	if len(asked) != 1 || asked[0] != "b" {
+		t.Errorf("loader keys = %v, want %v", asked, []string{"b"})
+	}
+}
+
+func TestCacheEmptyTransitionHook(t *testing.T) {
+	var transitions []bool
+	cache := New(10, WithEmptyTransitionHook(func(empty bool) {
+		transitions = append(transitions, empty)
+	}))
+	for _, key := range []string{"a", "b"} {
+		if err := cache.Set(key, testValue, 0); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	cache.Delete("a")
+	cache.Delete("b")
+	if err := cache.Set("c", testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Flush(); err != nil {
+		t.Errorf("Flush() = %v, want %v", err, nil)
+	}
+	cache.Delete("missing")
+
+	want := []bool{false, true, false, true}
+	if fmt.Sprint(transitions) != fmt.Sprint(want) {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestCacheEmptyTransitionHookReentrant(t *testing.T) {
+	var cache *Cache
+	var transitions []bool
+	cache = New(10, WithEmptyTransitionHook(func(empty bool) {
+		transitions = append(transitions, empty)
+		if !empty {
+			// Reported once this call has returned.
+			cache.Delete(testKey)
+		}
+	}))
+	if err := cache.Set(testKey, testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	want := []bool{false, true}
+	if fmt.Sprint(transitions) != fmt.Sprint(want) {
+		t.Errorf("transitions = %v, want %v", transitions, want)
+	}
+}
+
+func TestCacheCompareAndSwap(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, "v1", 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if ok, err := cache.CompareAndSwap(testKey, "other", "v2", 0); ok || err != nil {
+		t.Errorf("CompareAndSwap() = %v, %v, want %v, %v", ok, err, false, nil)
+	}
+	if ok, err := cache.CompareAndSwap(testKey, "v1", "v2", 0); !ok || err != nil {
+		t.Errorf("CompareAndSwap() = %v, %v, want %v, %v", ok, err, true, nil)
+	}
+	if value, err := cache.Get(testKey); err != nil || value != "v2" {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, "v2", nil)
+	}
+	if ok, err := cache.CompareAndSwap("missing", "", "v", 0); ok || err != nil {
+		t.Errorf("CompareAndSwap() = %v, %v, want %v, %v", ok, err, false, nil)
+	}
+}
+
+func TestCacheCompareAndSwapEqualFunc(t *testing.T) {
+	cache := New(10, WithEqualFunc(func(a, b string) bool {
+		return strings.Join(strings.Fields(a), "") == strings.Join(strings.Fields(b), "")
+	}))
+	if err := cache.Set(testKey, `{"a": 1}`, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if ok, err := cache.CompareAndSwap(testKey, `{"a":1}`, `{"a":2}`, 0); !ok || err != nil {
+		t.Errorf("CompareAndSwap() = %v, %v, want %v, %v", ok, err, true, nil)
+	}
+}
+
+func TestCacheGetByPrefix(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	for key, ttl := range map[string]time.Duration{"config:a": 0, "config:b": 0, "config:old": 1 * time.Minute, "user:a": 0} {
+		if err := cache.Set(key, key, ttl); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	now = now.Add(2 * time.Minute)
+	lru := cache.OrderedKeys()
+
+	want := map[string]string{"config:a": "config:a", "config:b": "config:b"}
+	if got := cache.GetByPrefix("config:"); !maps.Equal(got, want) {
+		t.Errorf("GetByPrefix() = %v, want %v", got, want)
+	}
+	if got := cache.OrderedKeys(); strings.Join(got, ",") != strings.Join(lru, ",") {
+		t.Errorf("OrderedKeys() = %v, want %v", got, lru)
+	}
+}
+
+func TestCacheEvictOneExpired(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var expired []string
+	cache := New(10,
+		WithClock(func() time.Time { return now }),
+		WithOnEvict(func(key, _ string, reason EvictReason) {
+			if reason == Expired {
+				expired = append(expired, key)
+			}
+		}),
+	)
+	for key, ttl := range map[string]time.Duration{"b": 2 * time.Minute, "a": 1 * time.Minute, "c": 3 * time.Minute, "live": 1 * time.Hour} {
+		if err := cache.Set(key, testValue, ttl); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	now = now.Add(5 * time.Minute)
+
+	var keys []string
+	for {
+		key, ok := cache.EvictOneExpired()
+		if !ok {
+			break
+		}
+		keys = append(keys, key)
+	}
+	want := []string{"a", "b", "c"}
+	if strings.Join(keys, ",") != strings.Join(want, ",") {
+		t.Errorf("EvictOneExpired() keys = %v, want %v", keys, want)
+	}
+	if strings.Join(expired, ",") != strings.Join(want, ",") {
+		t.Errorf("OnEvict expired = %v, want %v", expired, want)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Len() = %v, want %v", n, 1)
+	}
+}
+
+func TestCacheProtect(t *testing.T) {
+	cache := New(10, WithValueIndex())
+	for _, key := range []string{"flag", "config", "user1", "user2"} {
+		if err := cache.Set(key, "value-"+key, 0); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	cache.Protect("flag", "config")
+	if !cache.IsProtected("flag") || cache.IsProtected("user1") {
+		t.Errorf("IsProtected() = %v, %v, want %v, %v", cache.IsProtected("flag"), cache.IsProtected("user1"), true, false)
+	}
+
+	if err := cache.Flush(); err != nil {
+		t.Errorf("Flush() = %v, want %v", err, nil)
+	}
+	if got, want := cache.OrderedKeys(), []string{"config", "flag"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("OrderedKeys() = %v, want %v", got, want)
+	}
+	if key, ok := cache.KeyForValue("value-flag"); !ok || key != "flag" {
+		t.Errorf("KeyForValue() = %v, %v, want %v, %v", key, ok, "flag", true)
+	}
+
+	if n := cache.FlushFunc(func(string, string, time.Time) bool { return true }); n != 0 {
+		t.Errorf("FlushFunc() = %v, want %v", n, 0)
+	}
+	cache.Unprotect("config")
+	if n := cache.FlushFunc(func(string, string, time.Time) bool { return true }); n != 1 {
+		t.Errorf("FlushFunc() = %v, want %v", n, 1)
+	}
+
+	// Protection does not stop explicit deletes.
+	if !cache.Delete("flag") {
+		t.Errorf("Delete() = %v, want %v", false, true)
+	}
+}
+
+func TestCacheExpiryClusters(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	ttls := map[string]time.Duration{
+		"a": 1 * time.Minute, "b": 2 * time.Minute, "c": 4 * time.Minute,
+		"d": 11 * time.Minute, "forever": 0,
+	}
+	for key, ttl := range ttls {
+		if err := cache.Set(key, testValue, ttl); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+
+	want := map[time.Time]int{now: 3, now.Add(10 * time.Minute): 1}
+	if got := cache.ExpiryClusters(5 * time.Minute); !maps.Equal(got, want) {
+		t.Errorf("ExpiryClusters() = %v, want %v", got, want)
+	}
+	if got := cache.ExpiryClusters(0); got != nil {
+		t.Errorf("ExpiryClusters() = %v, want %v", got, nil)
+	}
+}
+
+func TestCacheDrain(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	reasons := make(map[string]EvictReason)
+	var expiredCallbacks []string
+	cache := New(10,
+		WithClock(func() time.Time { return now }),
+		WithOnEvict(func(key, _ string, reason EvictReason) {
+			reasons[key] = reason
+		}),
+		WithTombstones(1*time.Hour),
+	)
+	since := now
+	if err := cache.Set("a", "value-a", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("old", "value-old", 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	err := cache.SetWithCallback("b", "value-b", 0, func(key, _ string) {
+		expiredCallbacks = append(expiredCallbacks, key)
+	})
+	if err != nil {
+		t.Errorf("SetWithCallback() = %v, want %v", err, nil)
+	}
+	now = now.Add(2 * time.Minute)
+
+	drained := cache.Drain()
+	if len(drained) != 2 || drained[0].Key != "b" || drained[1].Key != "a" || drained[1].Value != "value-a" {
+		t.Errorf("Drain() = %v, want entries b and a", drained)
+	}
+	if !drained[1].ExpiryTime.Equal(now.Add(58 * time.Minute)) {
+		t.Errorf("Drain() expiry = %v, want %v", drained[1].ExpiryTime, now.Add(58*time.Minute))
+	}
+	if n := cache.Len(); n != 0 {
+		t.Errorf("Len() = %v, want %v", n, 0)
+	}
+	want := map[string]EvictReason{"a": Drained, "b": Drained, "old": Expired}
+	if !maps.Equal(reasons, want) {
+		t.Errorf("OnEvict reasons = %v, want %v", reasons, want)
+	}
+	if len(expiredCallbacks) != 0 {
+		t.Errorf("expiry callbacks = %v, want none", expiredCallbacks)
+	}
+	if changes := cache.ChangesSince(since); len(changes) != 0 {
+		t.Errorf("ChangesSince() = %v, want none", changes)
+	}
+}
+
+func TestCacheDrainExpired(t *testing.T) {
+	var evicted []string
+	cache := New(10, WithOnEvict(func(key, _ string, _ EvictReason) {
+		evicted = append(evicted, key)
+	}))
+	if err := cache.Set(testKey, testValue, 1*time.Millisecond); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key2", "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	drained := cache.DrainExpired()
+	if len(drained) != 1 || drained[0].Key != testKey || drained[0].Value != testValue || drained[0].ExpiryTime.IsZero() {
+		t.Errorf("DrainExpired() = %v, want only %v", drained, testKey)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Len() = %v, want %v", n, 1)
+	}
+	if len(evicted) != 1 {
+		t.Errorf("OnEvict keys = %v, want %v", evicted, []string{testKey})
+	}
+	if drained := cache.DrainExpired(); len(drained) != 0 {
+		t.Errorf("DrainExpired() = %v, want none", drained)
+	}
+}
+
+func TestCacheExpiryCallbackOrder(t *testing.T) {
+	for _, budget := range []int{0, 100} {
+		now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		var evicted []string
+		cache := New(100,
+			WithClock(func() time.Time { return now }),
+			WithSweepBudget(budget),
+			WithOnEvict(func(key, _ string, _ EvictReason) {
+				evicted = append(evicted, key)
+			}),
+		)
+		for _, i := range rand.Perm(50) {
+			if err := cache.Set(strconv.Itoa(i), testValue, time.Duration(i+1)*time.Second); err != nil {
+				t.Errorf("Set() = %v, want %v", err, nil)
+			}
+		}
+
+		now = now.Add(1 * time.Hour)
+		cache.evictExpiredItems()
+		if len(evicted) != 50 {
+			t.Fatalf("OnEvict called %d times, want %d", len(evicted), 50)
+		}
+		for i, key := range evicted {
+			if key != strconv.Itoa(i) {
+				t.Errorf("budget %d: OnEvict keys = %v, want ascending expiry order", budget, evicted)
+				break
+			}
+		}
+	}
+}
+
+func TestCacheWithInitialCapacityHint(t *testing.T) {
+	cache := New(2, WithInitialCapacityHint(100))
+	for _, key := range []string{"a", "b", "c"} {
+		if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Len() = %v, want %v", cache.Len(), 2)
+	}
+}
+
+func TestCacheWithFallback(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := WithClock(func() time.Time { return now })
+	fallback := New(10, clock)
+	if err := fallback.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	var misses []string
+	cache := New(10, clock, WithFallback(fallback), WithOnMiss(func(key string) {
+		misses = append(misses, key)
+	}))
+
+	now = now.Add(10 * time.Minute)
+	if value, err := cache.Get(testKey); err != nil || value != testValue {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if ttl, err := cache.TTL(testKey); err != nil || ttl != 50*time.Minute {
+		t.Errorf("TTL() = %v, %v, want %v, %v", ttl, err, 50*time.Minute, nil)
+	}
+	if _, err := cache.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() = %v, want %v", err, ErrNotFound)
+	}
+	if len(misses) != 1 {
+		t.Errorf("OnMiss keys = %v, want %v", misses, []string{"missing"})
+	}
+
+	if err := cache.Set("primary", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if fallback.Contains("primary") {
+		t.Errorf("contains failed: the key %s should not be exist", "primary")
+	}
+}
+
+func TestCacheWithOnMiss(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var misses []string
+	cache := New(10,
+		WithClock(func() time.Time { return now }),
+		WithOnMiss(func(key string) {
+			misses = append(misses, key)
+		}),
+	)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("expired", testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	now = now.Add(2 * time.Minute)
+
+	_, _ = cache.Get(testKey)
+	_ = cache.Contains(testKey)
+	_, _ = cache.Get("missing")
+	_ = cache.Contains("expired")
+	_, _ = cache.Get("expired")
+
+	want := []string{"missing", "expired", "expired"}
+	if strings.Join(misses, ",") != strings.Join(want, ",") {
+		t.Errorf("OnMiss keys = %v, want %v", misses, want)
+	}
+}
+
+func TestCacheGetRefreshIfBelow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	if err := cache.Set(testKey, testValue, 10*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	now = now.Add(3 * time.Minute)
+	if value, err := cache.GetRefreshIfBelow(testKey, 5*time.Minute, 10*time.Minute); err != nil || value != testValue {
+		t.Errorf("GetRefreshIfBelow() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if ttl, _ := cache.TTL(testKey); ttl != 7*time.Minute {
+		t.Errorf("TTL() = %v, want %v", ttl, 7*time.Minute)
+	}
+
+	now = now.Add(3 * time.Minute)
+	if _, err := cache.GetRefreshIfBelow(testKey, 5*time.Minute, 10*time.Minute); err != nil {
+		t.Errorf("GetRefreshIfBelow() = %v, want %v", err, nil)
+	}
+	if ttl, _ := cache.TTL(testKey); ttl != 10*time.Minute {
+		t.Errorf("TTL() = %v, want %v", ttl, 10*time.Minute)
+	}
+
+	now = now.Add(11 * time.Minute)
+	if _, err := cache.GetRefreshIfBelow(testKey, 5*time.Minute, 10*time.Minute); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRefreshIfBelow() = %v, want %v", err, ErrNotFound)
+	}
+	if _, err := cache.GetRefreshIfBelow(testKey, 5*time.Minute, 0); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("GetRefreshIfBelow() = %v, want %v", err, ErrInvalidTTL)
+	}
+}
+
+func TestCacheUpdate(t *testing.T) {
+	var deleted []string
+	cache := New(10, WithOnEvict(func(key, _ string, reason EvictReason) {
+		if reason == Deleted {
+			deleted = append(deleted, key)
+		}
+	}))
+	increment := func(old string, found bool) (string, bool, error) {
+		if !found {
+			return "1", true, nil
+		}
+		n, err := strconv.Atoi(old)
+		return strconv.Itoa(n + 1), true, err
+	}
+	for range 3 {
+		if err := cache.Update("counter", 0, increment); err != nil {
+			t.Errorf("Update() = %v, want %v", err, nil)
+		}
+	}
+	if value, err := cache.Get("counter"); err != nil || value != "3" {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, "3", nil)
+	}
+
+	if err := cache.Set("counter", "x", 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Update("counter", 0, increment); err == nil {
+		t.Errorf("Update() = %v, want error", err)
+	}
+	if value, err := cache.Get("counter"); err != nil || value != "x" {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, "x", nil)
+	}
+
+	err := cache.Update("counter", 0, func(string, bool) (string, bool, error) {
+		return "", false, nil
+	})
+	if err != nil {
+		t.Errorf("Update() = %v, want %v", err, nil)
+	}
+	if cache.Contains("counter") || len(deleted) != 1 {
+		t.Errorf("OnEvict deleted = %v, want %v", deleted, []string{"counter"})
+	}
+
+	if err := cache.Update("counter", -1, increment); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("Update() = %v, want %v", err, ErrInvalidTTL)
+	}
+}
+
+func TestCacheWeightedSampledEviction(t *testing.T) {
+	cache := New(10, WithWeightedSampledEviction(10))
+	for i := range 5 {
+		if err := cache.SetWithWeight("heavy"+strconv.Itoa(i), testValue, 30, 0); err != nil {
+			t.Errorf("SetWithWeight() = %v, want %v", err, nil)
+		}
+		if err := cache.Set("light"+strconv.Itoa(i), testValue, 0); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+
+	// Under strict LRU the heavy keys would be the first to go.
+	for i := range 50 {
+		if err := cache.Set("new"+strconv.Itoa(i), testValue, 0); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	for i := range 5 {
+		if key := "heavy" + strconv.Itoa(i); !cache.Contains(key) {
+			t.Errorf("contains failed: the key %s should be exist", key)
+		}
+		if key := "light" + strconv.Itoa(i); cache.Contains(key) {
+			t.Errorf("contains failed: the key %s should not be exist", key)
+		}
+	}
+	if n := cache.Len(); n != 10 {
+		t.Errorf("Len() = %v, want %v", n, 10)
+	}
+}
+
+func TestCacheGetRef(t *testing.T) {
+	cache := New(2)
+	for _, key := range []string{"a", "b"} {
+		if err := cache.Set(key, testValue, 0); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	value, release, err := cache.GetRef("a")
+	if err != nil || value != testValue {
+		t.Errorf("GetRef() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if _, err := cache.Get("b"); err != nil {
+		t.Errorf("Get() = %v, want %v", err, nil)
+	}
+
+	// The pinned entry is the least recently used one but survives.
+	if err := cache.Set("c", testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if !cache.Contains("a") || cache.Contains("b") {
+		t.Errorf("Keys() = %v, want %v", cache.Keys(), []string{"a", "c"})
+	}
+
+	release()
+	release()
+	if err := cache.Set("d", testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if cache.Contains("a") {
+		t.Errorf("contains failed: the key %s should not be exist", "a")
+	}
+
+	if _, _, err := cache.GetRef("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetRef() = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCacheSetMulti(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	err := cache.SetMulti(map[string]Entry{
+		"short":   {Value: "s", TTL: 1 * time.Minute},
+		"long":    {Value: "l", TTL: 1 * time.Hour},
+		"forever": {Value: "f"},
+	})
+	if err != nil {
+		t.Errorf("SetMulti() = %v, want %v", err, nil)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := cache.Get("short"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() = %v, want %v", err, ErrNotFound)
+	}
+	for key, want := range map[string]string{"long": "l", "forever": "f"} {
+		if value, err := cache.Get(key); err != nil || value != want {
+			t.Errorf("Get() = %v, %v, want %v, %v", value, err, want, nil)
+		}
+	}
+
+	err = cache.SetMulti(map[string]Entry{"a": {Value: "a"}, "b": {Value: "b", TTL: -1}})
+	if !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("SetMulti() = %v, want %v", err, ErrInvalidTTL)
+	}
+	if cache.Contains("a") {
+		t.Errorf("contains failed: the key %s should not be exist", "a")
+	}
+}
+
+func TestCacheSetMultiRejectOnFull(t *testing.T) {
+	cache := New(2, WithRejectOnFull())
+	if err := cache.Set("a", testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	err := cache.SetMulti(map[string]Entry{"a": {Value: "a2"}, "b": {Value: "b"}, "c": {Value: "c"}})
+	if !errors.Is(err, ErrCacheFull) {
+		t.Errorf("SetMulti() = %v, want %v", err, ErrCacheFull)
+	}
+	if err := cache.SetMulti(map[string]Entry{"a": {Value: "a2"}, "b": {Value: "b"}}); err != nil {
+		t.Errorf("SetMulti() = %v, want %v", err, nil)
+	}
+	if n := cache.Len(); n != 2 {
+		t.Errorf("Len() = %v, want %v", n, 2)
+	}
+}
+
+func TestCacheOrderedKeys(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if err := cache.Set("short", testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if _, err := cache.Get("b"); err != nil {
+		t.Errorf("Get() = %v, want %v", err, nil)
+	}
+	now = now.Add(2 * time.Minute)
+
+	want := []string{"b", "d", "c", "a"}
+	if got := cache.OrderedKeys(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("OrderedKeys() = %v, want %v", got, want)
+	}
+}
+
+func TestCachePauseExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var expired []string
+	cache := New(10,
+		WithClock(func() time.Time { return now }),
+		WithOnEvict(func(key, _ string, reason EvictReason) {
+			if reason == Expired {
+				expired = append(expired, key)
+			}
+		}),
+	)
+	if err := cache.Set("short", testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("long", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	cache.PauseExpiry()
+	now = now.Add(2 * time.Minute)
+	if value, err := cache.Get("short"); err != nil || value != testValue {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if !cache.Contains("short") {
+		t.Errorf("contains failed: the key %s should be exist", "short")
+	}
+	if ttl, err := cache.TTL("short"); err != nil || ttl != -1*time.Minute {
+		t.Errorf("TTL() = %v, %v, want %v, %v", ttl, err, -1*time.Minute, nil)
+	}
+	cache.evictExpiredItems()
+	if cache.Len() != 2 || len(expired) != 0 {
+		t.Errorf("sweep removed %v while expiry was paused", expired)
+	}
+
+	cache.ResumeExpiry()
+	if len(expired) != 1 || expired[0] != "short" {
+		t.Errorf("OnEvict expired = %v, want %v", expired, []string{"short"})
+	}
+	if !cache.Contains("long") || cache.Len() != 1 {
+		t.Errorf("Keys() = %v, want %v", cache.Keys(), []string{"long"})
+	}
+}
+
+func TestCacheNextExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	if _, ok := cache.NextExpiry(); ok {
+		t.Errorf("NextExpiry() ok = %v, want %v", ok, false)
+	}
+	if err := cache.Set("forever", testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if _, ok := cache.NextExpiry(); ok {
+		t.Errorf("NextExpiry() ok = %v, want %v", ok, false)
+	}
+
+	for _, ttl := range []time.Duration{1 * time.Hour, 1 * time.Second, 1 * time.Minute} {
+		if err := cache.Set(ttl.String(), testValue, ttl); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if next, ok := cache.NextExpiry(); !ok || !next.Equal(now.Add(1*time.Second)) {
+		t.Errorf("NextExpiry() = %v, %v, want %v, %v", next, ok, now.Add(1*time.Second), true)
+	}
+
+	now = now.Add(2 * time.Second)
+	if next, ok := cache.NextExpiry(); !ok || !next.Equal(now.Add(-2*time.Second+1*time.Minute)) {
+		t.Errorf("NextExpiry() = %v, %v, want %v, %v", next, ok, now.Add(-2*time.Second+1*time.Minute), true)
+	}
+}
+
+func TestCacheSetAtAndGetAt(t *testing.T) {
+	cache := New(10)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := cache.SetAt(testKey, testValue, 1*time.Minute, start); err != nil {
+		t.Errorf("SetAt() = %v, want %v", err, nil)
+	}
+
+	value, err := cache.GetAt(testKey, start.Add(30*time.Second))
+	if err != nil || value != testValue {
+		t.Errorf("GetAt() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if _, err := cache.GetAt(testKey, start.Add(2*time.Minute)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetAt() = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCacheWithClock(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	if err := cache.Set(testKey, testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if ttl, err := cache.TTL(testKey); err != nil || ttl != 1*time.Minute {
+		t.Errorf("TTL() = %v, %v, want %v, %v", ttl, err, 1*time.Minute, nil)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if cache.Contains(testKey) {
+		t.Errorf("contains failed: the key %s should not be exist", testKey)
+	}
+}
+
+func TestCacheVersions(t *testing.T) {
+	cache := New(10)
+
+	ok, err := cache.SetWithVersion(testKey, testValue, 0, 1*time.Hour)
+	if err != nil || !ok {
+		t.Errorf("SetWithVersion() = %v, %v, want %v, %v", ok, err, true, nil)
+	}
+	if err := cache.Set(testKey, "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	value, version, err := cache.GetWithVersion(testKey)
+	if err != nil || value != "value2" || version != 2 {
+		t.Errorf("GetWithVersion() = %v, %v, %v, want %v, %v, %v", value, version, err, "value2", 2, nil)
+	}
+
+	if ok, err := cache.SetWithVersion(testKey, "stale", 1, 1*time.Hour); err != nil || ok {
+		t.Errorf("SetWithVersion() = %v, %v, want %v, %v", ok, err, false, nil)
+	}
+	if ok, err := cache.SetWithVersion(testKey, "value3", 2, 1*time.Hour); err != nil || !ok {
+		t.Errorf("SetWithVersion() = %v, %v, want %v, %v", ok, err, true, nil)
+	}
+
+	// Versions start over once the key is deleted.
+	cache.Delete(testKey)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if _, version, _ := cache.GetWithVersion(testKey); version != 1 {
+		t.Errorf("GetWithVersion() version = %v, want %v", version, 1)
+	}
+}
+
+func TestCacheHighWaterMark(t *testing.T) {
+	var calls []int
+	cache := New(100, WithHighWaterMark(0.8, func(size, capacity int) {
+		if capacity != 100 {
+			t.Errorf("high water capacity = %v, want %v", capacity, 100)
+		}
+		calls = append(calls, size)
+	}))
+
+	for i := 0; i < 80; i++ {
+		if err := cache.Set(strconv.Itoa(i), testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	// Oscillating around the mark must not call fn again.
+	for i := 0; i < 3; i++ {
+		cache.Delete("79")
+		if err := cache.Set("79", testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if len(calls) != 1 || calls[0] != 80 {
+		t.Errorf("high water calls = %v, want %v", calls, []int{80})
+	}
+
+	// Dropping to 90% of the mark re-arms it.
+	for i := 72; i < 80; i++ {
+		cache.Delete(strconv.Itoa(i))
+	}
+	for i := 72; i < 80; i++ {
+		if err := cache.Set(strconv.Itoa(i), testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if len(calls) != 2 {
+		t.Errorf("high water calls = %v, want %d calls", calls, 2)
+	}
+
+	// Flush empties the cache without removing entries one by one and re-arms it too.
+	if err := cache.Flush(); err != nil {
+		t.Errorf("Flush() = %v, want %v", err, nil)
+	}
+	for i := 0; i < 80; i++ {
+		if err := cache.Set(strconv.Itoa(i), testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if len(calls) != 3 {
+		t.Errorf("high water calls = %v, want %d calls", calls, 3)
+	}
+}
+
+func TestCacheHighWaterMarkFollowsCapacity(t *testing.T) {
+	var calls []int
+	cache := New(100, WithHighWaterMark(0.5, func(size, capacity int) {
+		calls = append(calls, capacity)
+	}))
+	for i := 0; i < 20; i++ {
+		if err := cache.Set(strconv.Itoa(i), testValue, 0); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	// Shrinking the capacity to 40 moves the mark to 20.
+	cache.autoResize(40, 40, 0.9, 0, 0)
+	if len(calls) != 1 || calls[0] != 40 {
+		t.Errorf("high water calls = %v, want %v", calls, []int{40})
+	}
+}
+
+func TestCacheGetOrDefault(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	if value := cache.GetOrDefault(testKey, "fallback"); value != testValue {
+		t.Errorf("GetOrDefault() = %v, want %v", value, testValue)
+	}
+	if value := cache.GetOrDefault("missing", "fallback"); value != "fallback" {
+		t.Errorf("GetOrDefault() = %v, want %v", value, "fallback")
+	}
+}
+
+func TestCacheSweepBudget(t *testing.T) {
+	cache := New(10, WithSweepBudget(2))
+	for i := 0; i < 5; i++ {
+		key := strconv.Itoa(i)
+		if err := cache.Set(key, "value"+key, 1*time.Millisecond); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	time.Sleep(2 * time.Millisecond)
+
+	for _, want := range []int{3, 1, 0} {
+		cache.evictExpiredItems()
+		if n := cache.Len(); n != want {
+			t.Errorf("Len() = %v, want %v", n, want)
+		}
+	}
+	if err := cache.checkInvariants(); err != nil {
+		t.Errorf("checkInvariants() = %v, want %v", err, nil)
+	}
+}
+
+func TestCacheContainsDoesNotPromote(t *testing.T) {
+	cache := New(2)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("key2", "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	if !cache.Contains(testKey) {
+		t.Errorf("contains failed: the key %s should be exist", testKey)
+	}
+	if back := backKey(cache); back != testKey {
+		t.Errorf("Contains() promoted the entry, LRU = %v, want %v", back, testKey)
+	}
+}
+
+func BenchmarkContainsMiss(b *testing.B) {
+	cache := New(10)
+	_ = cache.Set(testKey, testValue, 1*time.Hour)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = cache.Contains("missing")
+	}
+}
+
+func BenchmarkContainsAll(b *testing.B) {
+	cache := New(100)
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+		if i%2 == 0 {
+			_ = cache.Set(keys[i], testValue, 1*time.Hour)
+		}
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = cache.ContainsAll(keys)
+	}
+}
+
+func TestCacheEntryInfo(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	created := now
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	now = now.Add(1 * time.Minute)
+	_, _ = cache.Get(testKey)
+	now = now.Add(1 * time.Minute)
+	_, _ = cache.Get(testKey)
+	if err := cache.Set(testKey, "value2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	gotCreated, lastAccess, count, ok := cache.EntryInfo(testKey)
+	if !ok || !gotCreated.Equal(created) || !lastAccess.Equal(now) || count != 2 {
+		t.Errorf("EntryInfo() = %v, %v, %v, %v, want %v, %v, %v, %v", gotCreated, lastAccess, count, ok, created, now, 2, true)
+	}
+	if _, _, _, ok := cache.EntryInfo("missing"); ok {
+		t.Errorf("EntryInfo() ok = %v, want %v", ok, false)
+	}
+}
+
+func TestCacheTrim(t *testing.T) {
+	var evicted []string
+	cache := New(10, WithOnEvict(func(key, _ string, reason EvictReason) {
+		if reason != Evicted {
+			t.Errorf("OnEvict reason = %v, want %v", reason, Evicted)
+		}
+		evicted = append(evicted, key)
+	}))
+	for i := 0; i < 5; i++ {
+		key := strconv.Itoa(i)
+		if err := cache.Set(key, "value"+key, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+
+	if n := cache.Trim(2); n != 3 {
+		t.Errorf("Trim() = %v, want %v", n, 3)
+	}
+	if !cache.Contains("3") || !cache.Contains("4") {
+		t.Errorf("Trim() evicted the most recently used entries")
+	}
+	if len(evicted) != 3 {
+		t.Errorf("OnEvict keys = %v, want %d keys", evicted, 3)
+	}
+	if n := cache.Trim(5); n != 0 {
+		t.Errorf("Trim() = %v, want %v", n, 0)
+	}
+}
+
+func TestCacheForEachLRU(t *testing.T) {
+	cache := New(10)
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if err := cache.Set(key, testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if err := cache.Expire("c", time.Now().Add(-1*time.Second)); err != nil {
+		t.Errorf("Expire() = %v, want %v", err, nil)
+	}
+	_, _ = cache.Get("a")
+
+	var keys []string
+	cache.ForEachLRU(func(key, _ string) bool {
+		keys = append(keys, key)
+		return len(keys) < 3
+	})
+	if strings.Join(keys, ",") != "b,d,a" {
+		t.Errorf("ForEachLRU() visited %v, want %v", keys, []string{"b", "d", "a"})
+	}
+}
+
+func TestCacheCallbackPanics(t *testing.T) {
+	var buf bytes.Buffer
+	var calls int
+	cache := New(1,
+		WithLogger(log.New(&buf, "", 0)),
+		WithOnEvict(func(_, _ string, _ EvictReason) {
+			calls++
+			panic("boom")
+		}),
+		WithOnMiss(func(string) {
+			panic("boom")
+		}),
+	)
+	onExpire := func(_, _ string) {
+		calls++
+		panic("boom")
+	}
+	if err := cache.SetWithCallback("a", testValue, 1*time.Hour, onExpire); err != nil {
+		t.Errorf("SetWithCallback() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("b", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if _, err := cache.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() = %v, want %v", err, ErrNotFound)
+	}
+
+	// Both callbacks of the evicted entry ran despite the first one panicking.
+	if calls != 2 {
+		t.Errorf("callbacks ran %d times, want %d", calls, 2)
+	}
+	if strings.Count(buf.String(), "panicked") != 3 {
+		t.Errorf("logged %q, want three panics", buf.String())
+	}
+	if !cache.mu.TryLock() {
+		t.Fatalf("cache lock is still held after a panicking callback")
+	}
+	cache.mu.Unlock()
+	if err := cache.Set("c", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+}
@@ -0,0 +1,63 @@
+package scache
+
+import (
+	"slices"
+	"time"
+)
+
+// ChangeOp is the kind of modification reported by ChangesSince.
+type ChangeOp int
+
+const (
+	// ChangeSet means the key was stored or its expiry was changed.
+	ChangeSet ChangeOp = iota
+	// ChangeDelete means the key was deleted explicitly.
+	ChangeDelete
+)
+
+// Change is a modification of a key reported by ChangesSince.
+type Change struct {
+	Key  string
+	Op   ChangeOp
+	Item CacheItem // The current item for ChangeSet, empty for ChangeDelete
+	Time time.Time // When the modification happened
+}
+
+// ChangesSince returns the latest modification of every key modified after t, oldest first.
+// Live entries are reported as ChangeSet. Explicit deletes are reported as ChangeDelete while
+// their tombstones are retained, see WithTombstones; without that option deletes leave no
+// trace. Expiry, eviction, Flush and Drain are not reported, as a peer applies its own.
+func (c *Cache) ChangesSince(t time.Time) []Change {
+	c.lock()
+	defer c.unlock()
+
+	now := c.now()
+	c.pruneTombstones(now)
+	var changes []Change
+	for elem := c.eviction.front(); elem != nil; elem = c.eviction.next(elem) {
+		kv, ok := c.entryOf(elem)
+		if !ok || c.expired(kv.value, now) || !kv.modified.After(t) {
+			continue
+		}
+		changes = append(changes, Change{Key: kv.key, Op: ChangeSet, Item: kv.value, Time: kv.modified})
+	}
+	for key, deleted := range c.tombstones {
+		if deleted.After(t) {
+			changes = append(changes, Change{Key: key, Op: ChangeDelete, Time: deleted})
+		}
+	}
+	slices.SortStableFunc(changes, func(a, b Change) int {
+		return a.Time.Compare(b.Time)
+	})
+	return changes
+}
+
+// pruneTombstones drops the tombstones older than the retention configured with
+// WithTombstones. The caller must hold the lock.
+func (c *Cache) pruneTombstones(now time.Time) {
+	for key, deleted := range c.tombstones {
+		if now.Sub(deleted) > c.tombstoneTTL {
+			delete(c.tombstones, key)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package scache
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheChangesSince(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }), WithTombstones(1*time.Hour))
+	if err := cache.Set("old", testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	since := now
+
+	now = now.Add(1 * time.Second)
+	if err := cache.Set(testKey, testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	now = now.Add(1 * time.Second)
+	if err := cache.Set("deleted", testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	now = now.Add(1 * time.Second)
+	cache.Delete("deleted")
+
+	if _, err := cache.Get("deleted"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() = %v, want %v", err, ErrNotFound)
+	}
+	changes := cache.ChangesSince(since)
+	if len(changes) != 2 {
+		t.Fatalf("ChangesSince() = %v, want %v changes", changes, 2)
+	}
+	if changes[0].Key != testKey || changes[0].Op != ChangeSet || changes[0].Item.Value != testValue {
+		t.Errorf("ChangesSince()[0] = %+v, want set of %v", changes[0], testKey)
+	}
+	if changes[1].Key != "deleted" || changes[1].Op != ChangeDelete || !changes[1].Time.Equal(now) {
+		t.Errorf("ChangesSince()[1] = %+v, want delete of %v", changes[1], "deleted")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if changes := cache.ChangesSince(since); len(changes) != 1 || changes[0].Key != testKey {
+		t.Errorf("ChangesSince() = %v, want only the set of %v", changes, testKey)
+	}
+}
+
+func TestCacheChangesSinceWithoutTombstones(t *testing.T) {
+	cache := New(10)
+	since := time.Now().Add(-1 * time.Second)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	cache.Delete(testKey)
+
+	if changes := cache.ChangesSince(since); len(changes) != 0 {
+		t.Errorf("ChangesSince() = %v, want none", changes)
+	}
+}
+
+func TestCacheChangesSinceMerge(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }), WithTombstones(1*time.Hour))
+	if err := cache.Set(testKey, testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	since := now
+	now = now.Add(1 * time.Second)
+	cache.Delete(testKey)
+
+	other := New(10)
+	if err := other.Set(testKey, "value2", 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	now = now.Add(1 * time.Second)
+	cache.Merge(other, Overwrite)
+
+	changes := cache.ChangesSince(since)
+	if len(changes) != 1 || changes[0].Op != ChangeSet || changes[0].Item.Value != "value2" || !changes[0].Time.Equal(now) {
+		t.Errorf("ChangesSince() = %+v, want only the set of %v", changes, testKey)
+	}
+	if created, _, _, ok := cache.EntryInfo(testKey); !ok || !created.Equal(now) {
+		t.Errorf("EntryInfo() = %v, %v, want %v, %v", created, ok, now, true)
+	}
+}
@@ -0,0 +1,51 @@
+package scache
+
+import (
+	"encoding/csv"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ExportCSV writes the live entries of the cache to w as comma-separated values, one row per
+// entry sorted by key, with the columns key, value and expiry. The expiry is formatted as
+// RFC 3339 or "never" for entries that never expire. Fields are quoted as needed.
+func (c *Cache) ExportCSV(w io.Writer) error {
+	return c.exportDelimited(w, ',')
+}
+
+// ExportTSV is like ExportCSV but separates the fields with tabs.
+func (c *Cache) ExportTSV(w io.Writer) error {
+	return c.exportDelimited(w, '\t')
+}
+
+// exportDelimited implements ExportCSV and ExportTSV.
+func (c *Cache) exportDelimited(w io.Writer, comma rune) error {
+	c.mu.RLock()
+	now := c.now()
+	entries := make([]CacheItemWithKey, 0, len(c.items))
+	for key, elem := range c.items {
+		if kv, ok := c.entryOf(elem); ok && !c.expired(kv.value, now) {
+			entries = append(entries, CacheItemWithKey{Key: key, CacheItem: kv.value})
+		}
+	}
+	c.mu.RUnlock()
+
+	slices.SortFunc(entries, func(a, b CacheItemWithKey) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	cw := csv.NewWriter(w)
+	cw.Comma = comma
+	for _, e := range entries {
+		expiry := "never"
+		if !e.ExpiryTime.IsZero() {
+			expiry = e.ExpiryTime.Format(time.RFC3339)
+		}
+		if err := cw.Write([]string{e.Key, e.Value, expiry}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
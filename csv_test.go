@@ -0,0 +1,61 @@
+package scache
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+	"time"
+)
+
+func TestCacheExportCSV(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	if err := cache.Set("b", "with, comma\nand newline", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("a", testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.SetAt("expired", testValue, 1*time.Second, now.Add(-1*time.Hour)); err != nil {
+		t.Errorf("SetAt() = %v, want %v", err, nil)
+	}
+
+	var buf bytes.Buffer
+	if err := cache.ExportCSV(&buf); err != nil {
+		t.Errorf("ExportCSV() = %v, want %v", err, nil)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll() = %v, want %v", err, nil)
+	}
+	want := [][]string{
+		{"a", testValue, "never"},
+		{"b", "with, comma\nand newline", "2024-01-01T01:00:00Z"},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("ExportCSV() wrote %v, want %v", records, want)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if records[i][j] != want[i][j] {
+				t.Errorf("ExportCSV() wrote %v, want %v", records, want)
+				return
+			}
+		}
+	}
+}
+
+func TestCacheExportTSV(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	var buf bytes.Buffer
+	if err := cache.ExportTSV(&buf); err != nil {
+		t.Errorf("ExportTSV() = %v, want %v", err, nil)
+	}
+	if want := testKey + "\t" + testValue + "\tnever\n"; buf.String() != want {
+		t.Errorf("ExportTSV() wrote %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,128 @@
+package scache
+
+import (
+	"context"
+	"time"
+)
+
+// Backoff bounds of lockCtx.
+const (
+	minLockBackoff = 1 * time.Microsecond
+	maxLockBackoff = 1 * time.Millisecond
+)
+
+// lockCtx acquires the write lock like lock, but gives up with ctx.Err() once ctx is done.
+//
+// sync.RWMutex cannot be cancelled, so lockCtx polls TryLock and sleeps between attempts,
+// doubling the sleep from 1µs up to 1ms. Under heavy contention a waiter therefore wakes up
+// about a thousand times per second at most, and it may be overtaken by goroutines blocked in
+// Lock, since TryLock does not queue. The context is checked before every attempt.
+func (c *Cache) lockCtx(ctx context.Context) error {
+	if c.lockMetrics == nil {
+		return acquireCtx(ctx, c.mu.TryLock)
+	}
+	start := time.Now()
+	if err := acquireCtx(ctx, c.mu.TryLock); err != nil {
+		return err
+	}
+	c.lockMetrics.record(time.Since(start))
+	return nil
+}
+
+// lockLookupCtx is like lockLookup but gives up with ctx.Err() once ctx is done, polling like
+// lockCtx.
+func (c *Cache) lockLookupCtx(ctx context.Context) (shared bool, err error) {
+	if c.frozen.Load() {
+		return true, acquireCtx(ctx, c.mu.TryRLock)
+	}
+	return false, c.lockCtx(ctx)
+}
+
+// acquireCtx calls try with the backoff described at lockCtx until it succeeds or ctx is done.
+func acquireCtx(ctx context.Context, try func() bool) error {
+	backoff := minLockBackoff
+	var timer *time.Timer
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if try() {
+			return nil
+		}
+
+		if timer == nil {
+			timer = time.NewTimer(backoff)
+			defer timer.Stop()
+		} else {
+			timer.Reset(backoff)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(2*backoff, maxLockBackoff)
+	}
+}
+
+// GetCtx is like Get but returns ctx.Err() if ctx is done before the cache lock could be
+// acquired. See lockCtx for the cost of waiting under contention. Like Get, a miss consults
+// the fallback cache and the spiller, which do not observe ctx.
+func (c *Cache) GetCtx(ctx context.Context, key string) (string, error) {
+	key = c.normalize(key)
+	shared, err := c.lockLookupCtx(ctx)
+	if err != nil {
+		return "", err
+	}
+	now := c.now()
+	value, err := c.getLocked(key, now)
+	c.unlockLookup(shared)
+
+	if err != nil {
+		return c.handleMiss(key, now, err)
+	}
+	return value, nil
+}
+
+// SetCtx is like Set but returns ctx.Err() without storing anything if ctx is done before the
+// cache lock could be acquired. With WithAsyncWrites it queues the entry like Set.
+func (c *Cache) SetCtx(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := c.validate(value, ttl); err != nil {
+		return err
+	}
+	kv := &entry{key: c.normalize(key), value: CacheItem{Value: value, ExpiryTime: expiryTime(c.now(), ttl)}}
+	if c.writes != nil {
+		return c.enqueue(kv)
+	}
+
+	if err := c.lockCtx(ctx); err != nil {
+		return err
+	}
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	return c.store(kv)
+}
+
+// GetOrSetCtx is like GetOrSet but stops waiting once ctx is done, both for a slot limited by
+// WithMaxConcurrentLoads and for a load of the same key started by another caller. It then
+// returns ctx.Err(), or the cached value if a live entry was being refreshed early. ctx is
+// not passed to loader, so a load that has started runs to completion for the other callers.
+// If ctx is done before the load got a slot, every caller sharing it receives ctx.Err().
+func (c *Cache) GetOrSetCtx(ctx context.Context, key string, ttl time.Duration, loader func() (string, error)) (string, error) {
+	if ttl < 0 {
+		return "", ErrInvalidTTL
+	}
+	return c.getOrLoad(ctx, key, func() (string, time.Duration, error) {
+		value, err := loader()
+		return value, ttl, err
+	})
+}
+
+// GetOrLoadCtx is like GetOrLoad but stops waiting once ctx is done, as described at
+// GetOrSetCtx.
+func (c *Cache) GetOrLoadCtx(ctx context.Context, key string, loader func() (value string, ttl time.Duration, err error)) (string, error) {
+	return c.getOrLoad(ctx, key, loader)
+}
@@ -0,0 +1,136 @@
+package scache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheGetCtxAndSetCtx(t *testing.T) {
+	cache := New(10)
+	ctx := context.Background()
+	if err := cache.SetCtx(ctx, testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("SetCtx() = %v, want %v", err, nil)
+	}
+	if value, err := cache.GetCtx(ctx, testKey); err != nil || value != testValue {
+		t.Errorf("GetCtx() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if _, err := cache.GetCtx(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("GetCtx() = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCacheGetCtxWithFallback(t *testing.T) {
+	fallback := New(10)
+	if err := fallback.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	cache := New(10, WithFallback(fallback))
+
+	if value, err := cache.GetCtx(context.Background(), testKey); err != nil || value != testValue {
+		t.Errorf("GetCtx() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if !cache.Contains(testKey) {
+		t.Errorf("contains failed: the key %s should be exist", testKey)
+	}
+}
+
+func TestCacheGetCtxTimeout(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	cache.mu.Lock()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.GetCtx(ctx, testKey); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetCtx() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := cache.SetCtx(ctx, "other", testValue, 1*time.Hour); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SetCtx() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// The lock is acquired once it is released before the deadline.
+	time.AfterFunc(5*time.Millisecond, cache.mu.Unlock)
+	ctx, cancel = context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if value, err := cache.GetCtx(ctx, testKey); err != nil || value != testValue {
+		t.Errorf("GetCtx() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if cache.Contains("other") {
+		t.Errorf("contains failed: the key %s should not be exist", "other")
+	}
+}
+
+func TestCacheGetOrSetCtxLoadSlots(t *testing.T) {
+	cache := New(10, WithMaxConcurrentLoads(1))
+	started, unblock := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = cache.GetOrSet("slow", 1*time.Hour, func() (string, error) {
+			close(started)
+			<-unblock
+			return testValue, nil
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	loaded := false
+	if _, err := cache.GetOrSetCtx(ctx, testKey, 1*time.Hour, func() (string, error) {
+		loaded = true
+		return testValue, nil
+	}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetOrSetCtx() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if loaded {
+		t.Errorf("loader called without a free load slot")
+	}
+	// Waiting for the load of another caller is cancelled as well.
+	if _, err := cache.GetOrLoadCtx(ctx, "slow", func() (string, time.Duration, error) {
+		return testValue, 0, nil
+	}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetOrLoadCtx() = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	close(unblock)
+	<-done
+	if value, err := cache.GetOrSetCtx(context.Background(), testKey, 1*time.Hour, func() (string, error) {
+		return testValue, nil
+	}); err != nil || value != testValue {
+		t.Errorf("GetOrSetCtx() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+}
+
+func TestCacheRunEvictionLoop(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Millisecond); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cache.RunEvictionLoop(ctx, 2*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for cache.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Len() = %v, want %v", cache.Len(), 0)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Errorf("RunEvictionLoop did not return after cancel")
+	}
+}
@@ -0,0 +1,139 @@
+package scache
+
+import (
+	"container/list"
+	"unsafe"
+)
+
+// listNode is the position of an entry in an evictionList. Each implementation hands out its
+// own kind of node, which is only ever passed back to the list that created it. The nil
+// listNode marks the end of the list.
+type listNode any
+
+// evictionList orders the entries of a Cache from the most recently used one at the front to
+// the least recently used one, the next to be evicted, at the back. The map of a Cache holds
+// the nodes returned by pushFront and pushBack, so every operation on an entry is O(1). The
+// caller must hold the lock of the cache.
+type evictionList interface {
+	pushFront(kv *entry) listNode
+	pushBack(kv *entry) listNode
+	moveToFront(n listNode)
+	// remove unlinks n. Removing a node that is no longer in the list has no effect.
+	remove(n listNode)
+	front() listNode
+	back() listNode
+	next(n listNode) listNode
+	prev(n listNode) listNode
+	// value returns what n holds, which is an *entry unless the list has been corrupted.
+	value(n listNode) any
+	len() int
+	// nodeSize is the memory used by a node on top of the entry, see Stats.EstimatedBytes.
+	nodeSize() int64
+}
+
+// newEvictionList returns an empty eviction list of the implementation selected with
+// WithIntrusiveList.
+func (c *Cache) newEvictionList() evictionList {
+	if c.intrusive {
+		return newIntrusiveList()
+	}
+	return &containerList{}
+}
+
+// containerList is the default evictionList, backed by container/list. Its nodes are
+// *list.Element values that hold the entries.
+type containerList struct {
+	l list.List
+}
+
+func (cl *containerList) pushFront(kv *entry) listNode { return cl.l.PushFront(kv) }
+func (cl *containerList) pushBack(kv *entry) listNode  { return cl.l.PushBack(kv) }
+func (cl *containerList) moveToFront(n listNode)       { cl.l.MoveToFront(n.(*list.Element)) }
+func (cl *containerList) remove(n listNode)            { cl.l.Remove(n.(*list.Element)) }
+func (cl *containerList) front() listNode              { return cl.node(cl.l.Front()) }
+func (cl *containerList) back() listNode               { return cl.node(cl.l.Back()) }
+func (cl *containerList) next(n listNode) listNode     { return cl.node(n.(*list.Element).Next()) }
+func (cl *containerList) prev(n listNode) listNode     { return cl.node(n.(*list.Element).Prev()) }
+func (cl *containerList) value(n listNode) any         { return n.(*list.Element).Value }
+func (cl *containerList) len() int                     { return cl.l.Len() }
+func (cl *containerList) nodeSize() int64              { return int64(unsafe.Sizeof(list.Element{})) }
+
+// node converts elem to a listNode, keeping a nil element from becoming a non-nil interface.
+func (cl *containerList) node(elem *list.Element) listNode {
+	if elem == nil {
+		return nil
+	}
+	return elem
+}
+
+// intrusiveList is an evictionList that links the entries through their prev and next
+// fields, so it allocates no nodes of its own: the nodes are the entries themselves. Like
+// container/list it is circular around a sentinel, which spares the nil checks on insertion
+// and removal.
+type intrusiveList struct {
+	root entry
+	n    int
+}
+
+func newIntrusiveList() *intrusiveList {
+	l := &intrusiveList{}
+	l.root.prev, l.root.next = &l.root, &l.root
+	return l
+}
+
+func (l *intrusiveList) pushFront(kv *entry) listNode {
+	l.insertAfter(kv, &l.root)
+	return kv
+}
+
+func (l *intrusiveList) pushBack(kv *entry) listNode {
+	l.insertAfter(kv, l.root.prev)
+	return kv
+}
+
+func (l *intrusiveList) moveToFront(n listNode) {
+	kv := n.(*entry)
+	if l.root.next == kv || kv.next == nil {
+		return
+	}
+	l.unlink(kv)
+	l.insertAfter(kv, &l.root)
+}
+
+func (l *intrusiveList) remove(n listNode) {
+	if kv := n.(*entry); kv.next != nil {
+		l.unlink(kv)
+	}
+}
+
+func (l *intrusiveList) front() listNode          { return l.node(l.root.next) }
+func (l *intrusiveList) back() listNode           { return l.node(l.root.prev) }
+func (l *intrusiveList) next(n listNode) listNode { return l.node(n.(*entry).next) }
+func (l *intrusiveList) prev(n listNode) listNode { return l.node(n.(*entry).prev) }
+func (l *intrusiveList) value(n listNode) any     { return n }
+func (l *intrusiveList) len() int                 { return l.n }
+func (l *intrusiveList) nodeSize() int64          { return 0 }
+
+// insertAfter links kv into the list right after at.
+func (l *intrusiveList) insertAfter(kv, at *entry) {
+	kv.prev, kv.next = at, at.next
+	at.next.prev = kv
+	at.next = kv
+	l.n++
+}
+
+// unlink removes kv from the list and clears its links, marking it as no longer linked.
+func (l *intrusiveList) unlink(kv *entry) {
+	kv.prev.next = kv.next
+	kv.next.prev = kv.prev
+	kv.prev, kv.next = nil, nil
+	l.n--
+}
+
+// node converts kv to a listNode, mapping the sentinel and nil to the nil listNode.
+func (l *intrusiveList) node(kv *entry) listNode {
+	if kv == nil || kv == &l.root {
+		return nil
+	}
+	return kv
+}
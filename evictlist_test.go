@@ -0,0 +1,153 @@
+package scache
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// frontKey returns the key of the most recently used entry of c.
+func frontKey(c *Cache) string {
+	return c.eviction.value(c.eviction.front()).(*entry).key
+}
+
+// backKey returns the key of the least recently used entry of c.
+func backKey(c *Cache) string {
+	return c.eviction.value(c.eviction.back()).(*entry).key
+}
+
+var evictionLists = []struct {
+	name string
+	opts []Option
+}{
+	{"container", nil},
+	{"intrusive", []Option{WithIntrusiveList()}},
+}
+
+func TestCacheEvictionLists(t *testing.T) {
+	for _, impl := range evictionLists {
+		t.Run(impl.name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			opts := append([]Option{WithClock(func() time.Time { return now }), WithSweepBudget(2)}, impl.opts...)
+			cache := New(3, opts...)
+			order := func(want string) {
+				t.Helper()
+				if got := strings.Join(cache.OrderedKeys(), ","); got != want {
+					t.Errorf("OrderedKeys() = %v, want %v", got, want)
+				}
+				if err := cache.checkInvariants(); err != nil {
+					t.Errorf("checkInvariants() = %v, want %v", err, nil)
+				}
+			}
+			set := func(key string, ttl time.Duration) {
+				t.Helper()
+				if err := cache.Set(key, testValue, ttl); err != nil {
+					t.Errorf("Set() = %v, want %v", err, nil)
+				}
+			}
+
+			for _, key := range []string{"a", "b", "c"} {
+				set(key, 1*time.Hour)
+			}
+			if _, err := cache.Get("a"); err != nil {
+				t.Errorf("Get() = %v, want %v", err, nil)
+			}
+			set("d", 1*time.Hour)
+			order("d,a,c")
+
+			cache.Delete("a")
+			set("e", 1*time.Hour)
+			set("f", 1*time.Hour)
+			order("f,e,d")
+
+			// The incremental sweep resumes at its cursor: the first run only reaches e and f.
+			set("g", 1*time.Minute)
+			now = now.Add(2 * time.Minute)
+			for _, want := range []int{3, 2} {
+				cache.evictExpiredItems()
+				if n := cache.Len(); n != want {
+					t.Errorf("Len() = %v, want %v", n, want)
+				}
+			}
+			order("f,e")
+			set("h", 1*time.Hour)
+			cache.Compact()
+			order("h,f,e")
+
+			if removed := cache.Repair(); removed != 0 {
+				t.Errorf("Repair() = %v, want %v", removed, 0)
+			}
+			if err := cache.HealthCheck(); err != nil {
+				t.Errorf("HealthCheck() = %v, want %v", err, nil)
+			}
+
+			other := New(3, impl.opts...)
+			if err := other.Set("z", testValue, 0); err != nil {
+				t.Errorf("Set() = %v, want %v", err, nil)
+			}
+			cache.SwapContents(other)
+			order("z")
+			if err := cache.Flush(); err != nil {
+				t.Errorf("Flush() = %v, want %v", err, nil)
+			}
+			order("")
+		})
+	}
+}
+
+func TestCacheWithIntrusiveList(t *testing.T) {
+	if _, ok := New(1).eviction.(*containerList); !ok {
+		t.Errorf("New() uses %T, want %T", New(1).eviction, &containerList{})
+	}
+	cache := New(1, WithIntrusiveList())
+	if _, ok := cache.eviction.(*intrusiveList); !ok {
+		t.Errorf("New() uses %T, want %T", cache.eviction, &intrusiveList{})
+	}
+	if err := cache.Set(testKey, testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	want := int64(len(testKey)+len(testValue)) + entryOverhead
+	if s := cache.Stats(); s.EstimatedBytes != want {
+		t.Errorf("Stats().EstimatedBytes = %v, want %v", s.EstimatedBytes, want)
+	}
+}
+
+func BenchmarkCacheSet(b *testing.B) {
+	const capacity = 10000
+	keys := make([]string, 4*capacity)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, impl := range evictionLists {
+		b.Run(impl.name, func(b *testing.B) {
+			cache := New(capacity, impl.opts...)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_ = cache.Set(keys[i%len(keys)], "value", 1*time.Hour)
+			}
+		})
+	}
+}
+
+func BenchmarkCacheGet(b *testing.B) {
+	const capacity = 10000
+	keys := make([]string, capacity)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+	for _, impl := range evictionLists {
+		b.Run(impl.name, func(b *testing.B) {
+			cache := New(capacity, impl.opts...)
+			for _, key := range keys {
+				_ = cache.Set(key, "value", 1*time.Hour)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = cache.Get(keys[(i*7919)%len(keys)])
+			}
+		})
+	}
+}
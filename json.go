@@ -0,0 +1,105 @@
+package scache
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// MarshalJSON encodes the live entries of the cache as a JSON object mapping each key to its
+// CacheItem.
+//
+// Expiry times are stored as absolute wall clock times in UTC rather than as remaining TTLs,
+// so entries restored by ImportJSON expire at their original deadline, however long after
+// the export and on whichever machine they are imported. With clock skew between the
+// exporting and the importing machine, entries live correspondingly longer or shorter there;
+// entries whose deadline has passed by the importer's clock are discarded.
+func (c *Cache) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.liveItems(nil))
+}
+
+// SaveFunc writes the live entries for which pred returns true to w in the JSON format of
+// MarshalJSON, so they can be restored with ImportJSON, e.g. to persist only long-lived
+// entries that are still worth having after a restart. pred is called with the expiry time of
+// each entry, which is zero for entries that never expire. It runs under the read lock, so it
+// must be fast and must not modify the cache. The entries are encoded after the lock has been
+// released.
+func (c *Cache) SaveFunc(w io.Writer, pred func(key, value string, expiry time.Time) bool) error {
+	return json.NewEncoder(w).Encode(c.liveItems(pred))
+}
+
+// liveItems returns the live entries of the cache for which pred returns true, or all of them
+// if pred is nil.
+func (c *Cache) liveItems(pred func(key, value string, expiry time.Time) bool) map[string]CacheItem {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.now()
+	items := make(map[string]CacheItem, len(c.items))
+	for key, elem := range c.items {
+		kv, ok := c.entryOf(elem)
+		if !ok || c.expired(kv.value, now) {
+			continue
+		}
+		if pred == nil || pred(key, kv.value.Value, kv.value.ExpiryTime) {
+			item := kv.value
+			item.ExpiryTime = item.ExpiryTime.UTC()
+			items[key] = item
+		}
+	}
+	return items
+}
+
+// ImportJSON reads a JSON object mapping keys to CacheItems, as produced by MarshalJSON, and
+// stores its live entries in the cache, evicting least recently used entries as needed.
+// Entries keep their absolute expiry time, and those already expired by the cache clock are
+// skipped; see MarshalJSON for the implications of clock skew. Exported versions are only
+// restored for keys that are not live in the cache; live keys get their next version. The
+// input is decoded and validated before the cache is touched, so malformed input leaves the
+// cache unchanged, and so does input that does not fit with WithRejectOnFull.
+func (c *Cache) ImportJSON(r io.Reader) error {
+	var items map[string]CacheItem
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := c.validate(item.Value, 0); err != nil {
+			return err
+		}
+	}
+
+	c.lock()
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	if c.frozen.Load() {
+		return ErrFrozen
+	}
+	now := c.now()
+	if c.rejectOnFull {
+		added := 0
+		for key, item := range items {
+			if _, found := c.items[c.normalize(key)]; !found && !item.expired(now) {
+				added++
+			}
+		}
+		if len(c.items)+added > c.capacity {
+			return ErrCacheFull
+		}
+	}
+	for key, item := range items {
+		if item.expired(now) {
+			continue
+		}
+		key = c.normalize(key)
+		if _, kv, found := c.lookup(key); found && !c.expired(kv.value, now) {
+			// A live key keeps counting its own versions.
+			item.Version = 0
+		}
+		if err := c.store(&entry{key: key, value: item}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package scache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheJSONRoundTrip(t *testing.T) {
+	src := New(10)
+	if err := src.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := src.Set("key2", "value2", 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	data, err := json.Marshal(src)
+	if err != nil {
+		t.Errorf("MarshalJSON() = %v, want %v", err, nil)
+	}
+
+	dst := New(10)
+	if err := dst.ImportJSON(bytes.NewReader(data)); err != nil {
+		t.Errorf("ImportJSON() = %v, want %v", err, nil)
+	}
+	for _, key := range []string{testKey, "key2"} {
+		want, _ := src.GetItem(key)
+		if got, err := dst.GetItem(key); err != nil || !got.ExpiryTime.Equal(want.ExpiryTime) || got.Value != want.Value {
+			t.Errorf("GetItem() = %v, %v, want %v, %v", got, err, want, nil)
+		}
+	}
+}
+
+func TestCacheImportJSONSkipsExpired(t *testing.T) {
+	cache := New(10)
+	input := `{"old": {"Value": "v", "ExpiryTime": "2000-01-01T00:00:00Z"}}`
+	if err := cache.ImportJSON(strings.NewReader(input)); err != nil {
+		t.Errorf("ImportJSON() = %v, want %v", err, nil)
+	}
+	if n := cache.Len(); n != 0 {
+		t.Errorf("Len() = %v, want %v", n, 0)
+	}
+}
+
+func TestCacheImportJSONMalformed(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	if err := cache.ImportJSON(strings.NewReader(`{"key2": {"Value": "v"`)); err == nil {
+		t.Errorf("ImportJSON() = %v, want error", err)
+	}
+	if n := cache.Len(); n != 1 {
+		t.Errorf("Len() = %v, want %v", n, 1)
+	}
+}
+
+func TestCacheImportJSONRejectOnFull(t *testing.T) {
+	cache := New(2, WithRejectOnFull())
+	if err := cache.Set(testKey, testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	input := `{"testKey": {"Value": "v"}, "key2": {"Value": "v"}, "key3": {"Value": "v"}}`
+	if err := cache.ImportJSON(strings.NewReader(input)); !errors.Is(err, ErrCacheFull) {
+		t.Errorf("ImportJSON() = %v, want %v", err, ErrCacheFull)
+	}
+	if keys := cache.Keys(); len(keys) != 1 || keys[0] != testKey {
+		t.Errorf("Keys() = %v, want %v", keys, []string{testKey})
+	}
+	if value, err := cache.Get(testKey); err != nil || value != testValue {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+}
+
+func TestCacheSaveFunc(t *testing.T) {
+	src := New(10)
+	if err := src.Set("short", "s", 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := src.Set("long", "l", 24*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := src.Set("forever", "f", 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	var buf bytes.Buffer
+	cutoff := time.Now().Add(1 * time.Hour)
+	err := src.SaveFunc(&buf, func(_, _ string, expiry time.Time) bool {
+		return expiry.IsZero() || expiry.After(cutoff)
+	})
+	if err != nil {
+		t.Errorf("SaveFunc() = %v, want %v", err, nil)
+	}
+
+	dst := New(10)
+	if err := dst.ImportJSON(&buf); err != nil {
+		t.Errorf("ImportJSON() = %v, want %v", err, nil)
+	}
+	if dst.Contains("short") || !dst.Contains("long") || !dst.Contains("forever") {
+		t.Errorf("Keys() = %v, want %v", dst.Keys(), []string{"long", "forever"})
+	}
+}
+
+func TestCacheMarshalJSONStoresUTCExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))
+	cache := New(10, WithClock(func() time.Time { return now }))
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	data, err := json.Marshal(cache)
+	if err != nil {
+		t.Errorf("MarshalJSON() = %v, want %v", err, nil)
+	}
+	if want := `"ExpiryTime":"2024-01-01T11:00:00Z"`; !strings.Contains(string(data), want) {
+		t.Errorf("MarshalJSON() = %s, want it to contain %s", data, want)
+	}
+}
+
+func TestCacheImportJSONVersions(t *testing.T) {
+	cache := New(10)
+	for i := 0; i < 5; i++ {
+		if err := cache.Set(testKey, testValue, 0); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+
+	input := `{"testKey": {"Value": "value2", "Version": 1}, "key2": {"Value": "v", "Version": 3}}`
+	if err := cache.ImportJSON(strings.NewReader(input)); err != nil {
+		t.Errorf("ImportJSON() = %v, want %v", err, nil)
+	}
+	if value, version, err := cache.GetWithVersion(testKey); err != nil || value != "value2" || version != 6 {
+		t.Errorf("GetWithVersion() = %v, %v, %v, want %v, %v, %v", value, version, err, "value2", 6, nil)
+	}
+	if ok, err := cache.SetWithVersion(testKey, "stale", 1, 0); err != nil || ok {
+		t.Errorf("SetWithVersion() = %v, %v, want %v, %v", ok, err, false, nil)
+	}
+	if _, version, err := cache.GetWithVersion("key2"); err != nil || version != 3 {
+		t.Errorf("GetWithVersion() = %v, %v, want %v, %v", version, err, 3, nil)
+	}
+}
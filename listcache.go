@@ -0,0 +1,62 @@
+package scache
+
+import (
+	"sync"
+	"time"
+)
+
+// ListCache stores a bounded list of values per key, e.g. the recent events of a user.
+// Push and List are atomic, so concurrent appends to the same key are never lost. Capacity,
+// LRU eviction and expiry are handled by an underlying Cache, with the whole list of a key
+// treated as a single entry.
+type ListCache[V any] struct {
+	mu    sync.Mutex
+	keys  *Cache // Only used while mu is held, so its callbacks run with mu held as well
+	lists map[string][]V
+}
+
+// NewListCache initializes and returns a new ListCache holding lists for at most capacity keys.
+func NewListCache[V any](capacity int) *ListCache[V] {
+	lc := &ListCache[V]{lists: make(map[string][]V)}
+	lc.keys = New(capacity, WithOnEvict(func(key, _ string, _ EvictReason) {
+		delete(lc.lists, key)
+	}))
+	return lc
+}
+
+// Push appends v to the list stored under key, dropping the oldest values so that at most
+// maxLen remain, and resets the expiry of the list to ttl. A maxLen of 0 or less keeps every
+// value and a zero ttl means the list never expires. Push returns ErrInvalidTTL if ttl is
+// negative.
+func (lc *ListCache[V]) Push(key string, v V, maxLen int, ttl time.Duration) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	// Get drops the list if it has expired.
+	if _, err := lc.keys.Get(key); err != nil {
+		delete(lc.lists, key)
+	}
+	if err := lc.keys.Set(key, "", ttl); err != nil {
+		return err
+	}
+
+	list := append(lc.lists[key], v)
+	if maxLen > 0 && len(list) > maxLen {
+		// Copy instead of reslicing so the dropped values can be garbage collected.
+		list = append([]V(nil), list[len(list)-maxLen:]...)
+	}
+	lc.lists[key] = list
+	return nil
+}
+
+// List returns a copy of the list stored under key, oldest value first, or nil if the key is
+// missing or expired.
+func (lc *ListCache[V]) List(key string) []V {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if _, err := lc.keys.Get(key); err != nil {
+		return nil
+	}
+	return append([]V(nil), lc.lists[key]...)
+}
@@ -0,0 +1,73 @@
+package scache
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheListCachePush(t *testing.T) {
+	cache := NewListCache[int](10)
+	for i := 1; i <= 5; i++ {
+		if err := cache.Push(testKey, i, 3, 1*time.Hour); err != nil {
+			t.Errorf("Push() = %v, want %v", err, nil)
+		}
+	}
+
+	list := cache.List(testKey)
+	if len(list) != 3 || list[0] != 3 || list[2] != 5 {
+		t.Errorf("List() = %v, want %v", list, []int{3, 4, 5})
+	}
+	list[0] = 42
+	if got := cache.List(testKey); got[0] != 3 {
+		t.Errorf("List() = %v, the returned slice aliases the cached list", got)
+	}
+	if list := cache.List("missing"); list != nil {
+		t.Errorf("List() = %v, want %v", list, nil)
+	}
+	if err := cache.Push(testKey, 6, 3, -1); !errors.Is(err, ErrInvalidTTL) {
+		t.Errorf("Push() = %v, want %v", err, ErrInvalidTTL)
+	}
+}
+
+func TestCacheListCacheExpiryAndEviction(t *testing.T) {
+	cache := NewListCache[string](2)
+	if err := cache.Push(testKey, testValue, 0, 1*time.Millisecond); err != nil {
+		t.Errorf("Push() = %v, want %v", err, nil)
+	}
+	time.Sleep(2 * time.Millisecond)
+	if err := cache.Push(testKey, "fresh", 0, 1*time.Hour); err != nil {
+		t.Errorf("Push() = %v, want %v", err, nil)
+	}
+	if list := cache.List(testKey); len(list) != 1 || list[0] != "fresh" {
+		t.Errorf("List() = %v, want %v", list, []string{"fresh"})
+	}
+
+	_ = cache.Push("b", testValue, 0, 1*time.Hour)
+	_ = cache.Push("c", testValue, 0, 1*time.Hour)
+	if list := cache.List(testKey); list != nil {
+		t.Errorf("List() = %v, want %v", list, nil)
+	}
+	if len(cache.lists) != 2 {
+		t.Errorf("len(lists) = %v, want %v", len(cache.lists), 2)
+	}
+}
+
+func TestCacheListCacheConcurrentPush(t *testing.T) {
+	cache := NewListCache[string](10)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_ = cache.Push(testKey, strconv.Itoa(i), 0, 1*time.Hour)
+		}(i)
+	}
+	wg.Wait()
+
+	if list := cache.List(testKey); len(list) != 100 {
+		t.Errorf("len(List()) = %v, want %v", len(list), 100)
+	}
+}
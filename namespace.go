@@ -0,0 +1,62 @@
+package scache
+
+import (
+	"strings"
+	"time"
+)
+
+// Namespace is a view of the entries of a Cache whose keys start with a prefix. Keys passed to
+// and returned by a Namespace omit the prefix, so code using it cannot see or modify entries
+// outside the namespace. A Namespace shares storage and capacity with its cache.
+type Namespace struct {
+	cache      *Cache
+	prefix     string
+	normalized string // The prefix of the stored keys, see WithKeyNormalizer
+}
+
+// Namespace returns a view of the entries whose keys start with prefix. Keys are normalized
+// together with the prefix, and the entries of the namespace are those whose stored keys
+// start with the normalized prefix, see WithKeyNormalizer.
+func (c *Cache) Namespace(prefix string) *Namespace {
+	return &Namespace{cache: c, prefix: prefix, normalized: c.normalize(prefix)}
+}
+
+// Set adds or updates the entry for key in the namespace. See Cache.Set.
+func (n *Namespace) Set(key, value string, ttl time.Duration) error {
+	return n.cache.Set(n.prefix+key, value, ttl)
+}
+
+// Get retrieves the entry for key in the namespace. See Cache.Get.
+func (n *Namespace) Get(key string) (string, error) {
+	return n.cache.Get(n.prefix + key)
+}
+
+// Delete removes the entry for key in the namespace. See Cache.Delete.
+func (n *Namespace) Delete(key string) bool {
+	return n.cache.Delete(n.prefix + key)
+}
+
+// Keys returns the keys of all live entries in the namespace, without the prefix, in no
+// particular order.
+func (n *Namespace) Keys() []string {
+	var keys []string
+	for _, key := range n.cache.Keys() {
+		if rest, ok := strings.CutPrefix(key, n.normalized); ok {
+			keys = append(keys, rest)
+		}
+	}
+	return keys
+}
+
+// Flush removes all entries in the namespace. Unlike Cache.Flush, the global eviction
+// callback is called with reason Deleted for every removed entry. It returns ErrFrozen if the
+// cache has been frozen.
+func (n *Namespace) Flush() error {
+	if n.cache.frozen.Load() {
+		return ErrFrozen
+	}
+	n.cache.FlushFunc(func(key, _ string, _ time.Time) bool {
+		return strings.HasPrefix(key, n.normalized)
+	})
+	return nil
+}
@@ -0,0 +1,48 @@
+package scache
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheNamespace(t *testing.T) {
+	cache := New(10)
+	orders := cache.Namespace("orders:")
+	users := cache.Namespace("users:")
+	for _, key := range []string{"1", "2"} {
+		if err := orders.Set(key, "order-"+key, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if err := users.Set("1", "user-1", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	if value, err := orders.Get("1"); err != nil || value != "order-1" {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, "order-1", nil)
+	}
+	if value, err := cache.Get("users:1"); err != nil || value != "user-1" {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, "user-1", nil)
+	}
+	keys := orders.Keys()
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "1,2" {
+		t.Errorf("Keys() = %v, want %v", keys, []string{"1", "2"})
+	}
+
+	if err := orders.Flush(); err != nil {
+		t.Errorf("Flush() = %v, want %v", err, nil)
+	}
+	if len(orders.Keys()) != 0 || cache.Len() != 1 {
+		t.Errorf("Flush() left %v, want only %v", cache.Keys(), []string{"users:1"})
+	}
+	if !users.Delete("1") {
+		t.Errorf("Delete() = %v, want %v", false, true)
+	}
+	if _, err := users.Get("1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() = %v, want %v", err, ErrNotFound)
+	}
+}
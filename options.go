@@ -0,0 +1,306 @@
+package scache
+
+import (
+	"time"
+)
+
+// Option configures a Cache created by New.
+type Option func(*Cache)
+
+// WithMaxValueSize limits the length in bytes of values stored by Set.
+// Larger values are rejected with ErrValueTooLarge. A size of 0 disables the limit.
+func WithMaxValueSize(size int) Option {
+	return func(c *Cache) {
+		c.maxValueSize = size
+	}
+}
+
+// WithRejectOnFull makes Set return ErrCacheFull for new keys when the cache is at
+// capacity instead of evicting the least recently used item.
+func WithRejectOnFull() Option {
+	return func(c *Cache) {
+		c.rejectOnFull = true
+	}
+}
+
+// WithOnEvict registers fn to be called whenever an entry is removed from the cache because
+// it expired, was evicted to make room or was deleted. Overwrites and Flush do not trigger it.
+// The callback runs after the cache lock has been released. Entries expired by the same sweep
+// are reported in ascending order of expiry time.
+func WithOnEvict(fn func(key, value string, reason EvictReason)) Option {
+	return func(c *Cache) {
+		c.onEvict = fn
+	}
+}
+
+// WithAsyncWrites makes Set queue entries in a buffer of the given size and return
+// immediately, while a background goroutine applies them to the cache. Reads observe a
+// write only once it has been applied. Set returns ErrQueueFull when the buffer is full,
+// and Close applies all queued writes before returning. A size of 0 keeps writes synchronous.
+func WithAsyncWrites(bufferSize int) Option {
+	return func(c *Cache) {
+		if bufferSize > 0 {
+			c.writes = make(chan *entry, bufferSize)
+		}
+	}
+}
+
+// WithKeyNormalizer applies fn to every key passed to the cache before it is stored or looked
+// up, e.g. strings.ToLower for case-insensitive keys. Keys returns the normalized keys.
+func WithKeyNormalizer(fn func(string) string) Option {
+	return func(c *Cache) {
+		c.normalizer = fn
+	}
+}
+
+// WithPromotionThreshold makes Get move an entry to the front of the LRU order only every n
+// accesses instead of on every access. This approximates LRU while reducing list updates for
+// very hot keys. Values below 2 promote on every access.
+func WithPromotionThreshold(n int) Option {
+	return func(c *Cache) {
+		c.promoteAfter = n
+	}
+}
+
+// WithEvictionBatchSize makes a cache that runs out of room and whose least recently used
+// entry has expired remove the expired entries among its n least recently used ones at once,
+// with reason Expired, instead of evicting one item per insert. When many entries expire at
+// the same time, the following inserts then find room without evicting. Live entries are
+// still evicted one at a time, so the cache stays at capacity. The default of 1 evicts the
+// least recently used item whether or not it has expired.
+func WithEvictionBatchSize(n int) Option {
+	return func(c *Cache) {
+		c.evictBatch = n
+	}
+}
+
+// WithValueIndex maintains a reverse index from values to keys so KeyForValue can find the
+// key holding a value. It is only meaningful when values are unique: if several keys hold
+// the same value, the index points at the key written last.
+func WithValueIndex() Option {
+	return func(c *Cache) {
+		c.valueIndex = make(map[string]string)
+	}
+}
+
+// WithLogger sets the logger used to report unexpected internal conditions, such as a
+// corrupted eviction list node being dropped. By default nothing is logged.
+func WithLogger(logger Logger) Option {
+	return func(c *Cache) {
+		c.logger = logger
+	}
+}
+
+// WithClock sets the function the cache uses to read the current time, which defaults to
+// time.Now. It is mainly useful to control expiry deterministically in tests.
+func WithClock(now func() time.Time) Option {
+	return func(c *Cache) {
+		c.clock = now
+	}
+}
+
+// WithHighWaterMark registers fn to be called when the number of entries rises to the given
+// fraction of the capacity. To avoid a storm of calls while the size oscillates around the
+// mark, fn is called again only after the size has dropped to 90% of the mark or below.
+// fn runs after the cache lock has been released.
+func WithHighWaterMark(fraction float64, fn func(size, capacity int)) Option {
+	return func(c *Cache) {
+		c.highWater = &highWater{
+			fn:       fn,
+			fraction: fraction,
+			armed:    true,
+		}
+	}
+}
+
+// WithSweepBudget limits each run of the eviction ticker to examining n entries, so the write
+// lock is only held briefly even on very large caches. The next run resumes where the
+// previous one stopped, walking from the least to the most recently used entry. A budget of
+// 0 sweeps the whole cache on every tick.
+func WithSweepBudget(n int) Option {
+	return func(c *Cache) {
+		c.sweepBudget = n
+	}
+}
+
+// WithLockMetrics makes every acquisition of the write lock, e.g. by Set, Get or Delete, record
+// how long it waited, reported by Stats. Read-only methods such as Contains and Peek, which
+// only take the read lock, are not timed. It is off by default because timing every
+// acquisition adds overhead.
+func WithLockMetrics() Option {
+	return func(c *Cache) {
+		c.lockMetrics = &lockMetrics{}
+	}
+}
+
+// WithShardHasher sets the hash function NewSharded uses to pick the shard for a key, which
+// is hash(key) masked to the number of shards. The default is 64-bit FNV-1a. The option has
+// no effect on caches created by New.
+func WithShardHasher(fn func(key string) uint64) Option {
+	return func(c *Cache) {
+		c.shardHasher = fn
+	}
+}
+
+// WithOnMiss registers fn to be called with the normalized key whenever Get or Contains finds
+// no live entry, whether the key is missing or expired. The callback runs after the cache lock
+// has been released and is called for every miss, so expensive work should be debounced.
+func WithOnMiss(fn func(key string)) Option {
+	return func(c *Cache) {
+		c.onMiss = fn
+	}
+}
+
+// WithTombstones makes explicit deletes leave a tombstone that is retained for the given
+// duration, so ChangesSince can report the delete to a peer. Tombstones are dropped by the
+// expiry sweep and by ChangesSince once they are older than retention, and when the key is
+// stored again. Get treats a tombstoned key as missing.
+func WithTombstones(retention time.Duration) Option {
+	return func(c *Cache) {
+		c.tombstones = make(map[string]time.Time)
+		c.tombstoneTTL = retention
+	}
+}
+
+// WithMaxTTL caps the lifetime of every entry at d: a longer TTL or later expiry time passed
+// to any write, Expire or Persist is silently shortened to d from the time the entry is
+// stored, and the clamping is logged with the logger configured with WithLogger. Entries that
+// would never expire, such as those stored with a zero TTL, are clamped as well. A d of 0
+// disables the cap.
+func WithMaxTTL(d time.Duration) Option {
+	return func(c *Cache) {
+		c.maxTTL = d
+	}
+}
+
+// WithFallback makes Get consult fallback when a key is missing or expired. A live entry found
+// there is copied into the cache with its remaining lifetime and returned, so only misses in
+// both caches count as misses. Writes go to the cache only; fallback is never modified.
+func WithFallback(fallback *Cache) Option {
+	return func(c *Cache) {
+		c.fallback = fallback
+	}
+}
+
+// WithSpiller makes the cache hand entries evicted to make room to s instead of dropping them,
+// and makes Get restore a missing key from s, after consulting the fallback cache if one is
+// configured. Spill runs synchronously after the cache lock has been released, before the
+// eviction callbacks. Spill errors are logged with the logger configured with WithLogger and
+// otherwise ignored, so the cache keeps working when s cannot store entries.
+func WithSpiller(s Spiller) Option {
+	return func(c *Cache) {
+		c.spiller = s
+	}
+}
+
+// WithInitialCapacityHint preallocates room for n entries so the cache does not have to grow
+// its internal map while it is filled. The hint is independent of the capacity passed to New.
+func WithInitialCapacityHint(n int) Option {
+	return func(c *Cache) {
+		if n > 0 {
+			c.items = make(map[string]listNode, n)
+		}
+	}
+}
+
+// WithEarlyExpiration makes GetOrSet and GetOrLoad occasionally treat a live entry as
+// expired shortly before it actually expires, so one caller refreshes it while the others
+// keep getting the cached value instead of all missing at once. The probability of an early
+// refresh grows as the entry approaches its expiry time and with how long its loader took;
+// beta scales it, with 1 being a good default and larger values refreshing earlier. Only
+// entries stored by GetOrSet or GetOrLoad are refreshed early. A beta of 0 disables early
+// expiration.
+func WithEarlyExpiration(beta float64) Option {
+	return func(c *Cache) {
+		c.earlyBeta = beta
+	}
+}
+
+// WithTopK makes the cache track the k keys with the most hits, reported by TopKeys. Hits are
+// counted approximately in a fixed-size sketch, so the memory used depends only on k and not
+// on the number of distinct keys. It is off by default.
+func WithTopK(k int) Option {
+	return func(c *Cache) {
+		if k > 0 {
+			c.topK = newTopK(k)
+		}
+	}
+}
+
+// WithWeightedSampledEviction replaces strict LRU eviction by a weighted random pick among the
+// k least recently used entries, in which entries with a higher weight, set with
+// SetWithWeight, are exponentially less likely to be evicted. This keeps entries that are
+// expensive to rebuild resident even if they have not been used recently. A k of 1 or less
+// keeps strict LRU eviction.
+func WithWeightedSampledEviction(k int) Option {
+	return func(c *Cache) {
+		if k > 1 {
+			c.evictSample = k
+		}
+	}
+}
+
+// WithEqualFunc sets the function CompareAndSwap uses to compare the stored value with the
+// expected one, e.g. to treat JSON values that only differ in whitespace or field order as
+// equal. The default is ==. The function runs with the cache lock held, so it must be cheap
+// and deterministic and must not call back into the cache.
+func WithEqualFunc(fn func(a, b string) bool) Option {
+	return func(c *Cache) {
+		c.equalFunc = fn
+	}
+}
+
+// WithEmptyTransitionHook sets a function that is called with true when the cache becomes
+// empty and with false when it stops being empty, e.g. to run the eviction ticker only while
+// there is something to evict. It is called after the lock has been released, so it may call
+// back into the cache. Transitions are detected when a write or removal releases the lock, and
+// rapid oscillation is debounced: calls never repeat the previous state, and states that have
+// been superseded by the time the hook returns from the previous call are skipped.
+func WithEmptyTransitionHook(fn func(empty bool)) Option {
+	return func(c *Cache) {
+		c.onEmpty = fn
+		c.isEmpty.Store(true)
+		c.emptyReported = true
+	}
+}
+
+// WithMaxConcurrentLoads limits the number of loader calls made by GetOrSet, GetOrLoad and
+// GetOrSetMulti that run at the same time to n, so a burst of misses across many distinct
+// keys cannot overwhelm the backend. Callers beyond the limit wait for a running load to
+// complete; use GetOrSetCtx or GetOrLoadCtx to give up once a context is done. Waiting callers
+// still share loads per key, so they do not take extra slots. A batch load of GetOrSetMulti
+// takes a single slot. An n of 0 or less means no limit, which is the default.
+//
+// A loader holds its slot while it runs, so a loader that itself calls GetOrSet or GetOrLoad
+// for a missing key needs a second slot and deadlocks once all n slots are held by such
+// loaders. Avoid nested loads, or use the context variants with a deadline.
+func WithMaxConcurrentLoads(n int) Option {
+	return func(c *Cache) {
+		if n > 0 {
+			c.loadSlots = make(chan struct{}, n)
+		}
+	}
+}
+
+// WithHitRatioWindow makes the cache count hits and misses in time buckets covering the
+// trailing window, reported by RecentHitRatio. The window is split into 60 buckets, so memory
+// use is fixed and RecentHitRatio has a resolution of a sixtieth of the window. Counting takes
+// an extra clock read and a short lock per lookup. It is off by default.
+func WithHitRatioWindow(window time.Duration) Option {
+	return func(c *Cache) {
+		if width := window / hitWindowBuckets; width > 0 {
+			c.hitWindow = &hitWindow{width: width}
+		}
+	}
+}
+
+// WithIntrusiveList links the entries into the eviction list through pointers stored in the
+// entries themselves instead of container/list nodes. This saves one allocation per stored
+// entry, which speeds up write-heavy workloads, while lookups are unaffected. By default the
+// cache uses container/list, whose separate nodes let Repair detect and drop nodes that hold
+// no entry.
+func WithIntrusiveList() Option {
+	return func(c *Cache) {
+		c.intrusive = true
+	}
+}
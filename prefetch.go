@@ -0,0 +1,69 @@
+package scache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Prefetch loads keys concurrently with at most concurrency calls to loader in flight and
+// stores the results with the given TTL. A failing key does not stop the others; all loader
+// errors are returned joined together.
+func (c *Cache) Prefetch(keys []string, concurrency int, ttl time.Duration, loader func(key string) (string, error)) error {
+	return c.PrefetchContext(context.Background(), keys, concurrency, ttl, loader)
+}
+
+// PrefetchContext is like Prefetch but stops starting new loads once ctx is done, in which
+// case the context error is included in the returned error.
+func (c *Cache) PrefetchContext(ctx context.Context, keys []string, concurrency int, ttl time.Duration, loader func(key string) (string, error)) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var (
+		mu   sync.Mutex
+		errs []error
+		wg   sync.WaitGroup
+	)
+	jobs := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range jobs {
+				value, err := loader(key)
+				if err == nil {
+					err = c.Set(key, value, ttl)
+				}
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("prefetch %q: %w", key, err))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	// Workers only append to errs until wg.Wait returns, so the context error is
+	// recorded separately.
+	var ctxErr error
+dispatch:
+	for _, key := range keys {
+		if ctxErr = ctx.Err(); ctxErr != nil {
+			break
+		}
+		select {
+		case jobs <- key:
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	errs = append(errs, ctxErr)
+	return errors.Join(errs...)
+}
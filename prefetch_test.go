@@ -0,0 +1,60 @@
+package scache
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCachePrefetch(t *testing.T) {
+	cache := New(100)
+	errLoad := errors.New("load failed")
+	var inFlight, maxInFlight atomic.Int32
+	loader := func(key string) (string, error) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		if key == "3" {
+			return "", errLoad
+		}
+		return "value" + key, nil
+	}
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	err := cache.Prefetch(keys, 4, 1*time.Hour, loader)
+	if !errors.Is(err, errLoad) {
+		t.Errorf("Prefetch() = %v, want %v", err, errLoad)
+	}
+	if n := maxInFlight.Load(); n > 4 {
+		t.Errorf("Prefetch() ran %d loaders concurrently, want at most %d", n, 4)
+	}
+	if n := cache.Len(); n != 19 {
+		t.Errorf("Len() = %v, want %v", n, 19)
+	}
+}
+
+func TestCachePrefetchContextCancelled(t *testing.T) {
+	cache := New(100)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := cache.PrefetchContext(ctx, []string{testKey}, 1, 1*time.Hour, func(string) (string, error) {
+		return testValue, nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("PrefetchContext() = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,81 @@
+package scache
+
+import "time"
+
+// ReadOnlyCache is a view of a Cache that only exposes read operations.
+// It delegates to the underlying cache, so reads always reflect its current contents.
+type ReadOnlyCache struct {
+	c *Cache
+}
+
+// ReadOnly returns a read-only view of the cache.
+func (c *Cache) ReadOnly() ReadOnlyCache {
+	return ReadOnlyCache{c: c}
+}
+
+// Get retrieves a cache entry by its key, see Cache.Get.
+func (r ReadOnlyCache) Get(key string) (string, error) {
+	return r.c.Get(key)
+}
+
+// Peek retrieves a cache entry without promoting it, see Cache.Peek.
+func (r ReadOnlyCache) Peek(key string) (string, error) {
+	return r.c.Peek(key)
+}
+
+// Contains checks if cached key exists in the cache, see Cache.Contains.
+func (r ReadOnlyCache) Contains(key string) bool {
+	return r.c.Contains(key)
+}
+
+// Len returns the number of entries in the cache, see Cache.Len.
+func (r ReadOnlyCache) Len() int {
+	return r.c.Len()
+}
+
+// Keys returns the keys of all live entries, see Cache.Keys.
+func (r ReadOnlyCache) Keys() []string {
+	return r.c.Keys()
+}
+
+// TTL returns the remaining time to live of an entry, see Cache.TTL.
+func (r ReadOnlyCache) TTL(key string) (time.Duration, error) {
+	return r.c.TTL(key)
+}
+
+// FrozenCache is an immutable snapshot of the live entries of a Cache, taken by
+// FreezeSnapshot. It never changes after it has been taken, so it takes no locks and can be
+// read concurrently without any contention. Entries expired at snapshot time are excluded,
+// but the snapshot does not check expiry afterwards: it returns entries that have expired
+// since, until it is replaced by a new snapshot.
+type FrozenCache struct {
+	items     map[string]string
+	normalize func(string) string
+}
+
+// FreezeSnapshot returns an immutable snapshot of the live entries of the cache, e.g. to serve
+// a read-mostly configuration from a hot path and re-snapshot whenever it changes. Taking the
+// snapshot copies every entry under the read lock.
+func (c *Cache) FreezeSnapshot() *FrozenCache {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.now()
+	items := make(map[string]string, len(c.items))
+	for key, elem := range c.items {
+		if kv, ok := c.entryOf(elem); ok && !c.expired(kv.value, now) {
+			items[key] = kv.value.Value
+		}
+	}
+	return &FrozenCache{items: items, normalize: c.normalize}
+}
+
+// Get returns the value stored for key in the snapshot and whether it was found.
+func (f *FrozenCache) Get(key string) (string, bool) {
+	value, found := f.items[f.normalize(key)]
+	return value, found
+}
+
+// Len returns the number of entries in the snapshot.
+func (f *FrozenCache) Len() int {
+	return len(f.items)
+}
@@ -0,0 +1,66 @@
+package scache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadOnlyCache(t *testing.T) {
+	cache := New(10)
+	view := cache.ReadOnly()
+
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	value, err := view.Get(testKey)
+	if err != nil || value != testValue {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if value, err := view.Peek(testKey); err != nil || value != testValue {
+		t.Errorf("Peek() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if !view.Contains(testKey) {
+		t.Errorf("contains failed: the key %s should be exist", testKey)
+	}
+	if n := view.Len(); n != 1 {
+		t.Errorf("Len() = %v, want %v", n, 1)
+	}
+	if keys := view.Keys(); len(keys) != 1 || keys[0] != testKey {
+		t.Errorf("Keys() = %v, want %v", keys, []string{testKey})
+	}
+	if _, err := view.TTL(testKey); err != nil {
+		t.Errorf("TTL() = %v, want %v", err, nil)
+	}
+
+	cache.Delete(testKey)
+	if view.Contains(testKey) {
+		t.Errorf("contains failed: the key %s should not be exist", testKey)
+	}
+}
+
+func TestCacheFreezeSnapshot(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("expired", testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	now = now.Add(2 * time.Minute)
+
+	snapshot := cache.FreezeSnapshot()
+	if err := cache.Set(testKey, "changed", 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if value, found := snapshot.Get(testKey); !found || value != testValue {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, found, testValue, true)
+	}
+	if _, found := snapshot.Get("expired"); found {
+		t.Errorf("Get() found expired key %s", "expired")
+	}
+	if n := snapshot.Len(); n != 1 {
+		t.Errorf("Len() = %v, want %v", n, 1)
+	}
+}
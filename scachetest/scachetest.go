@@ -0,0 +1,99 @@
+// Package scachetest provides helpers for testing code that uses scache: assertions on the
+// state of a cache and a fake clock for deterministic, sleep-free expiry tests.
+//
+// The assertions only use the public API of the cache and do not promote entries, so they
+// leave the LRU order unchanged.
+package scachetest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/safr/scache"
+)
+
+// Clock is a fake clock for scache.WithClock that only moves when told to. It is safe for
+// concurrent use.
+//
+//	clock := scachetest.NewClock(time.Now())
+//	cache := scache.New(10, scache.WithClock(clock.Now))
+//	clock.Advance(time.Minute)
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a Clock showing the given time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to the given time.
+func (c *Clock) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// AssertContains reports an error unless the cache holds a live entry for key.
+func AssertContains(t testing.TB, c *scache.Cache, key string) {
+	t.Helper()
+	if _, err := c.Peek(key); err != nil {
+		t.Errorf("cache does not contain key %q: %v", key, err)
+	}
+}
+
+// AssertNotContains reports an error if the cache holds a live entry for key.
+func AssertNotContains(t testing.TB, c *scache.Cache, key string) {
+	t.Helper()
+	if value, err := c.Peek(key); err == nil {
+		t.Errorf("cache contains key %q with value %q, want none", key, value)
+	}
+}
+
+// AssertValue reports an error unless the cache holds a live entry for key with the given
+// value.
+func AssertValue(t testing.TB, c *scache.Cache, key, want string) {
+	t.Helper()
+	if value, err := c.Peek(key); err != nil {
+		t.Errorf("cache does not contain key %q: %v", key, err)
+	} else if value != want {
+		t.Errorf("cache value of key %q = %q, want %q", key, value, want)
+	}
+}
+
+// AssertExpired reports an error unless key is stored in the cache but has expired, i.e. it
+// is not returned by lookups but has not been removed by a lookup or sweep yet.
+func AssertExpired(t testing.TB, c *scache.Cache, key string) {
+	t.Helper()
+	switch expired, present := c.IsExpired(key); {
+	case !present:
+		t.Errorf("cache does not contain key %q, want an expired entry", key)
+	case !expired:
+		t.Errorf("cache key %q has not expired", key)
+	}
+}
+
+// AssertLen reports an error unless the cache holds n entries. Like Len, it includes expired
+// entries that have not been removed yet.
+func AssertLen(t testing.TB, c *scache.Cache, n int) {
+	t.Helper()
+	if got := c.Len(); got != n {
+		t.Errorf("cache Len() = %d, want %d", got, n)
+	}
+}
@@ -0,0 +1,64 @@
+package scachetest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/safr/scache"
+)
+
+// recorder is a testing.TB that records errors instead of failing the test.
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertions(t *testing.T) {
+	clock := NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	cache := scache.New(10, scache.WithClock(clock.Now))
+	if err := cache.Set("short", "s", 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("long", "l", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	clock.Advance(2 * time.Minute)
+
+	AssertContains(t, cache, "long")
+	AssertValue(t, cache, "long", "l")
+	AssertNotContains(t, cache, "short")
+	AssertNotContains(t, cache, "missing")
+	AssertExpired(t, cache, "short")
+	AssertLen(t, cache, 2)
+
+	r := &recorder{TB: t}
+	AssertContains(r, cache, "short")
+	AssertValue(r, cache, "long", "other")
+	AssertNotContains(r, cache, "long")
+	AssertExpired(r, cache, "long")
+	AssertExpired(r, cache, "missing")
+	AssertLen(r, cache, 1)
+	if len(r.errors) != 6 {
+		t.Errorf("errors = %q, want %v", r.errors, 6)
+	}
+}
+
+func TestClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewClock(start)
+	clock.Advance(time.Minute)
+	if got := clock.Now(); !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("Now() = %v, want %v", got, start.Add(time.Minute))
+	}
+	clock.Set(start)
+	if got := clock.Now(); !got.Equal(start) {
+		t.Errorf("Now() = %v, want %v", got, start)
+	}
+}
@@ -0,0 +1,98 @@
+package scache
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ShardedCache spreads keys over several independent caches to reduce lock contention.
+// Every shard has its own lock, LRU order and capacity, so eviction is only approximately
+// LRU across the whole cache.
+type ShardedCache struct {
+	shards []*Cache
+	hasher func(key string) uint64
+	mask   uint64
+}
+
+// NewSharded initializes and returns a ShardedCache with numShards shards sharing the given
+// total capacity. The options are applied to every shard. numShards must be a power of two
+// so the shard index can be computed with a mask; NewSharded panics otherwise.
+func NewSharded(numShards, capacity int, opts ...Option) *ShardedCache {
+	if numShards <= 0 || numShards&(numShards-1) != 0 {
+		panic(fmt.Sprintf("scache: number of shards must be a power of two, got %d", numShards))
+	}
+
+	perShard := (capacity + numShards - 1) / numShards
+	s := &ShardedCache{
+		shards: make([]*Cache, numShards),
+		mask:   uint64(numShards - 1),
+	}
+	for i := range s.shards {
+		s.shards[i] = New(perShard, opts...)
+	}
+	s.hasher = s.shards[0].shardHasher
+	if s.hasher == nil {
+		s.hasher = fnv1a
+	}
+	return s
+}
+
+// fnv1a returns the 64-bit FNV-1a hash of key without allocating.
+func fnv1a(key string) uint64 {
+	const (
+		offset64 = 14695981039346656037
+		prime64  = 1099511628211
+	)
+	h := uint64(offset64)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= prime64
+	}
+	return h
+}
+
+// Shard returns the shard responsible for key.
+func (s *ShardedCache) Shard(key string) *Cache {
+	// Normalize before hashing so that keys the shards consider equal share a shard.
+	return s.shards[s.hasher(s.shards[0].normalize(key))&s.mask]
+}
+
+// Set adds or updates a cache entry in the shard responsible for key. See Cache.Set.
+func (s *ShardedCache) Set(key, value string, ttl time.Duration) error {
+	return s.Shard(key).Set(key, value, ttl)
+}
+
+// Get retrieves a value from the shard responsible for key. See Cache.Get.
+func (s *ShardedCache) Get(key string) (string, error) {
+	return s.Shard(key).Get(key)
+}
+
+// Contains reports whether key is present and not expired. See Cache.Contains.
+func (s *ShardedCache) Contains(key string) bool {
+	return s.Shard(key).Contains(key)
+}
+
+// Delete removes the entry with the given key. See Cache.Delete.
+func (s *ShardedCache) Delete(key string) bool {
+	return s.Shard(key).Delete(key)
+}
+
+// Len returns the number of entries in all shards, including expired entries that have not
+// been removed yet.
+func (s *ShardedCache) Len() int {
+	n := 0
+	for _, shard := range s.shards {
+		n += shard.Len()
+	}
+	return n
+}
+
+// Close closes every shard. See Cache.Close.
+func (s *ShardedCache) Close() error {
+	var errs []error
+	for _, shard := range s.shards {
+		errs = append(errs, shard.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,67 @@
+package scache
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheSharded(t *testing.T) {
+	cache := NewSharded(4, 100)
+	for i := 0; i < 50; i++ {
+		if err := cache.Set(strconv.Itoa(i), testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	if cache.Len() != 50 {
+		t.Errorf("Len() = %v, want %v", cache.Len(), 50)
+	}
+
+	value, err := cache.Get("7")
+	if err != nil || value != testValue {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if !cache.Delete("7") {
+		t.Errorf("Delete() = %v, want %v", false, true)
+	}
+	if cache.Contains("7") {
+		t.Errorf("contains failed: the key %s should not be exist", "7")
+	}
+	if err := cache.Close(); err != nil {
+		t.Errorf("Close() = %v, want %v", err, nil)
+	}
+}
+
+func TestCacheShardedHasher(t *testing.T) {
+	cache := NewSharded(4, 100,
+		WithKeyNormalizer(strings.ToLower),
+		WithShardHasher(func(key string) uint64 { return uint64(len(key)) }),
+	)
+	if err := cache.Set("ABC", testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	if cache.Shard("abc") != cache.shards[3] {
+		t.Errorf("Shard() did not use the configured hasher")
+	}
+	if cache.shards[3].Len() != 1 {
+		t.Errorf("shard Len() = %v, want %v", cache.shards[3].Len(), 1)
+	}
+	if !cache.Contains("abc") {
+		t.Errorf("contains failed: the key %s should be exist", "abc")
+	}
+}
+
+func TestCacheShardedPowerOfTwo(t *testing.T) {
+	for _, n := range []int{0, 3, 6} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewSharded(%d) did not panic", n)
+				}
+			}()
+			NewSharded(n, 100)
+		}()
+	}
+}
@@ -0,0 +1,76 @@
+package scache
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapSpiller is a Spiller backed by a map.
+type mapSpiller struct {
+	mu    sync.Mutex
+	items map[string]CacheItem
+	err   error
+}
+
+func (s *mapSpiller) Spill(key, value string, expiry time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.items[key] = CacheItem{Value: value, ExpiryTime: expiry}
+	return nil
+}
+
+func (s *mapSpiller) Restore(key string) (string, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[key]
+	return item.Value, item.ExpiryTime, ok
+}
+
+func TestCacheWithSpiller(t *testing.T) {
+	spiller := &mapSpiller{items: make(map[string]CacheItem)}
+	cache := New(1, WithSpiller(spiller))
+	if err := cache.Set("a", "1", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("b", "2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if _, ok := spiller.items["a"]; !ok {
+		t.Errorf("evicted key %s was not spilled", "a")
+	}
+
+	if value, err := cache.Get("a"); err != nil || value != "1" {
+		t.Errorf("Get() = %v, %v, want %v, %v", value, err, "1", nil)
+	}
+	if _, ok := spiller.items["b"]; !ok {
+		t.Errorf("evicted key %s was not spilled", "b")
+	}
+	if _, err := cache.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() = %v, want %v", err, ErrNotFound)
+	}
+}
+
+func TestCacheWithSpillerError(t *testing.T) {
+	var buf bytes.Buffer
+	spiller := &mapSpiller{items: make(map[string]CacheItem), err: errors.New("disk full")}
+	cache := New(1, WithSpiller(spiller), WithLogger(log.New(&buf, "", 0)))
+	if err := cache.Set("a", "1", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("b", "2", 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if buf.Len() == 0 {
+		t.Errorf("spill failure was not logged")
+	}
+	if !cache.Contains("b") {
+		t.Errorf("contains failed: the key %s should be exist", "b")
+	}
+}
@@ -0,0 +1,239 @@
+package scache
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// entryOverhead estimates the memory used by the cache for every entry on top of its key,
+// value and metadata: the entry itself and its slot in the map, including the map's spare
+// capacity. The eviction list node, if the list allocates one, comes on top.
+const entryOverhead = int64(unsafe.Sizeof(entry{}) + 48)
+
+// Stats is a snapshot of the cache statistics.
+type Stats struct {
+	// Entries is the number of entries, including expired entries not removed yet.
+	Entries int
+	// EstimatedBytes is a rough estimate of the memory held by the entries: their keys,
+	// values and metadata plus a fixed per-entry overhead for the internal data structures.
+	// It ignores memory shared between entries and is meant for sizing, not accounting.
+	EstimatedBytes int64
+
+	// Hits is the number of lookups served from the cache by Get and its variants.
+	Hits int64
+	// Misses is the number of lookups by Get and its variants that found no live entry, so
+	// every lookup counts as either a hit or a miss. Lookups answered by the fallback cache
+	// or the spiller count as neither hits nor misses.
+	Misses int64
+
+	// LockAcquisitions is the number of timed write lock acquisitions, which covers every
+	// method that modifies the cache or promotes entries. It is only recorded with
+	// WithLockMetrics.
+	LockAcquisitions int64
+	// LockWaitTotal is the total time spent waiting for the write lock.
+	LockWaitTotal time.Duration
+	// LockWaitMax is the longest time a single acquisition waited for the write lock.
+	LockWaitMax time.Duration
+
+	// ListInsertions is the number of entries added to the eviction list, one per stored
+	// entry, including overwrites.
+	ListInsertions int64
+	// ListRemovals is the number of entries removed from the eviction list, whether deleted,
+	// evicted, expired or replaced by an overwrite.
+	ListRemovals int64
+	// ListPromotions is the number of entries moved to the front of the eviction list by
+	// lookups. It is at most Hits, and lower with WithPromotionThreshold or when entries are
+	// already at the front.
+	ListPromotions int64
+
+	// SweptExpired is the number of expired entries removed by sweeps, i.e. by the eviction
+	// ticker, DrainExpired and EvictOneExpired.
+	SweptExpired int64
+	// ExpiryLagTotal is the total time the swept entries stayed in the cache after expiring.
+	// A large average suggests running the eviction ticker more often.
+	ExpiryLagTotal time.Duration
+	// ExpiryLagMax is the longest time a swept entry stayed in the cache after expiring.
+	ExpiryLagMax time.Duration
+}
+
+// HitRatio returns the fraction of lookups that were hits, or 0 if there were none.
+func (s Stats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// listOps counts the mutations of the eviction list. The counters are atomic so Stats can read
+// them without the lock.
+type listOps struct {
+	insertions atomic.Int64
+	removals   atomic.Int64
+	promotions atomic.Int64
+}
+
+// hitWindowBuckets is the number of time buckets tracked by WithHitRatioWindow.
+const hitWindowBuckets = 60
+
+// hitWindow counts hits and misses in a ring of time buckets covering the configured window.
+type hitWindow struct {
+	mu      sync.Mutex
+	width   time.Duration
+	buckets [hitWindowBuckets]hitBucket
+}
+
+// hitBucket holds the counts of one bucket, identified by its index since the Unix epoch.
+type hitBucket struct {
+	index        int64
+	hits, misses int64
+}
+
+// record counts a lookup at now in its bucket, reusing the slot of an outdated bucket.
+func (w *hitWindow) record(now time.Time, hit bool) {
+	index := now.UnixNano() / int64(w.width)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	b := &w.buckets[(index%hitWindowBuckets+hitWindowBuckets)%hitWindowBuckets]
+	if b.index != index {
+		*b = hitBucket{index: index}
+	}
+	if hit {
+		b.hits++
+	} else {
+		b.misses++
+	}
+}
+
+// countLookup counts a lookup for Stats and, with WithHitRatioWindow, RecentHitRatio.
+func (c *Cache) countLookup(hit bool) {
+	if hit {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
+	if c.hitWindow != nil {
+		c.hitWindow.record(c.now(), hit)
+	}
+}
+
+// RecentHitRatio returns the fraction of the lookups counted by Stats as Hits and Misses in
+// the trailing window that were hits, or 0 if there were none. Unlike the lifetime ratio of
+// Stats it reveals a recent drop, e.g. after a deploy invalidated the cache. It requires
+// WithHitRatioWindow; window is rounded up to whole buckets and capped at the configured
+// window.
+func (c *Cache) RecentHitRatio(window time.Duration) float64 {
+	w := c.hitWindow
+	if w == nil || window <= 0 {
+		return 0
+	}
+	current := c.now().UnixNano() / int64(w.width)
+	n := min(int64((window+w.width-1)/w.width), hitWindowBuckets)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var hits, misses int64
+	for _, b := range w.buckets {
+		if b.index > current-n && b.index <= current {
+			hits += b.hits
+			misses += b.misses
+		}
+	}
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// LockWaitAvg returns the average time Set and Get waited for the write lock.
+func (s Stats) LockWaitAvg() time.Duration {
+	if s.LockAcquisitions == 0 {
+		return 0
+	}
+	return s.LockWaitTotal / time.Duration(s.LockAcquisitions)
+}
+
+// ExpiryLagAvg returns the average time the swept entries stayed in the cache after expiring.
+func (s Stats) ExpiryLagAvg() time.Duration {
+	if s.SweptExpired == 0 {
+		return 0
+	}
+	return s.ExpiryLagTotal / time.Duration(s.SweptExpired)
+}
+
+// Stats returns a snapshot of the cache statistics. Computing EstimatedBytes scans the whole
+// cache under the read lock, so Stats is meant for periodic sampling, not hot paths.
+func (c *Cache) Stats() Stats {
+	s := Stats{
+		Hits:           c.hits.Load(),
+		Misses:         c.misses.Load(),
+		ListInsertions: c.listOps.insertions.Load(),
+		ListRemovals:   c.listOps.removals.Load(),
+		ListPromotions: c.listOps.promotions.Load(),
+	}
+	if m := c.lockMetrics; m != nil {
+		s.LockAcquisitions = m.count.Load()
+		s.LockWaitTotal = time.Duration(m.total.Load())
+		s.LockWaitMax = time.Duration(m.max.Load())
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s.Entries = len(c.items)
+	overhead := entryOverhead + c.eviction.nodeSize()
+	for _, elem := range c.items {
+		if kv, ok := c.entryOf(elem); ok {
+			s.EstimatedBytes += int64(kv.size()) + overhead
+		}
+	}
+	s.SweptExpired = c.expiryLag.count
+	s.ExpiryLagTotal = c.expiryLag.total
+	s.ExpiryLagMax = c.expiryLag.max
+	return s
+}
+
+// durationStats aggregates durations recorded while the cache lock is held.
+type durationStats struct {
+	count int64
+	total time.Duration
+	max   time.Duration
+}
+
+// record adds a single duration.
+func (d *durationStats) record(v time.Duration) {
+	d.count++
+	d.total += v
+	d.max = max(d.max, v)
+}
+
+// lockMetrics records how long callers waited for the write lock.
+type lockMetrics struct {
+	count atomic.Int64
+	total atomic.Int64 // Nanoseconds
+	max   atomic.Int64 // Nanoseconds
+}
+
+// record adds a single lock wait.
+func (m *lockMetrics) record(wait time.Duration) {
+	m.count.Add(1)
+	m.total.Add(int64(wait))
+	for {
+		current := m.max.Load()
+		if int64(wait) <= current || m.max.CompareAndSwap(current, int64(wait)) {
+			return
+		}
+	}
+}
+
+// lock acquires the write lock, timing the wait if WithLockMetrics is set. The measurement
+// uses the wall clock rather than the cache clock and is taken outside the lock.
+func (c *Cache) lock() {
+	if c.lockMetrics == nil {
+		c.mu.Lock()
+		return
+	}
+	start := time.Now()
+	c.mu.Lock()
+	c.lockMetrics.record(time.Since(start))
+}
@@ -0,0 +1,173 @@
+package scache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestCacheLockMetrics(t *testing.T) {
+	cache := New(10, WithLockMetrics())
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = cache.Set(testKey, testValue, 1*time.Hour)
+				_, _ = cache.Get(testKey)
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := cache.Stats()
+	if stats.LockAcquisitions != 2000 {
+		t.Errorf("Stats().LockAcquisitions = %v, want %v", stats.LockAcquisitions, 2000)
+	}
+	if stats.LockWaitMax < stats.LockWaitAvg() || stats.LockWaitTotal < stats.LockWaitMax {
+		t.Errorf("Stats() = %+v, inconsistent lock wait times", stats)
+	}
+
+	// Every other write lock acquisition is timed as well.
+	cache.Delete(testKey)
+	_ = cache.SetMulti(map[string]Entry{testKey: {Value: testValue}})
+	_, _ = cache.CompareAndSwap(testKey, testValue, "new", 0)
+	_, _ = cache.GetCtx(context.Background(), testKey)
+	if n := cache.Stats().LockAcquisitions; n != 2004 {
+		t.Errorf("Stats().LockAcquisitions = %v, want %v", n, 2004)
+	}
+
+	if stats := New(10).Stats(); stats.LockAcquisitions != 0 {
+		t.Errorf("Stats().LockAcquisitions = %v, want %v", stats.LockAcquisitions, 0)
+	}
+}
+
+func TestCacheExpiryLagStats(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }))
+	if err := cache.Set("a", testValue, 1*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if err := cache.Set("b", testValue, 3*time.Minute); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	now = now.Add(4 * time.Minute)
+	cache.evictExpiredItems()
+	stats := cache.Stats()
+	if stats.SweptExpired != 2 {
+		t.Errorf("Stats().SweptExpired = %v, want %v", stats.SweptExpired, 2)
+	}
+	if stats.ExpiryLagMax != 3*time.Minute {
+		t.Errorf("Stats().ExpiryLagMax = %v, want %v", stats.ExpiryLagMax, 3*time.Minute)
+	}
+	if stats.ExpiryLagAvg() != 2*time.Minute {
+		t.Errorf("Stats().ExpiryLagAvg() = %v, want %v", stats.ExpiryLagAvg(), 2*time.Minute)
+	}
+}
+
+func TestCacheMemoryEstimate(t *testing.T) {
+	cache := New(10)
+	if stats := cache.Stats(); stats.Entries != 0 || stats.EstimatedBytes != 0 {
+		t.Errorf("Stats() = %+v, want no entries and no bytes", stats)
+	}
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+
+	stats := cache.Stats()
+	want := int64(len(testKey)+len(testValue)) + entryOverhead + int64(unsafe.Sizeof(list.Element{}))
+	if stats.Entries != 1 || stats.EstimatedBytes != want {
+		t.Errorf("Stats() = %+v, want %v entry and %v bytes", stats, 1, want)
+	}
+}
+
+func TestCacheListOpStats(t *testing.T) {
+	cache := New(2)
+	for _, key := range []string{"a", "b", "a", "c"} {
+		if err := cache.Set(key, testValue, 0); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	// The first lookup promotes a, the second finds it at the front already.
+	for range 2 {
+		if _, err := cache.Get("a"); err != nil {
+			t.Errorf("Get() = %v, want %v", err, nil)
+		}
+	}
+	cache.Delete("c")
+
+	s := cache.Stats()
+	if s.ListInsertions != 4 || s.ListRemovals != 3 || s.ListPromotions != 1 {
+		t.Errorf("Stats() insertions, removals, promotions = %v, %v, %v, want %v, %v, %v",
+			s.ListInsertions, s.ListRemovals, s.ListPromotions, 4, 3, 1)
+	}
+	if s.Hits != 2 {
+		t.Errorf("Stats().Hits = %v, want %v", s.Hits, 2)
+	}
+}
+
+func TestCacheHitsAndMisses(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Every lookup below runs once for a present and once for a missing key.
+	for _, key := range []string{testKey, "missing"} {
+		_, _ = cache.Get(key)
+		_, _ = cache.GetCtx(context.Background(), key)
+		_, _, _ = cache.GetWithVersion(key)
+		_, _, _ = cache.GetWithMeta(key)
+		_, _ = cache.GetRefreshIfBelow(key, time.Minute, time.Hour)
+		if _, release, err := cache.GetRef(key); err == nil {
+			release()
+		}
+		_, _ = cache.GetOrWait(ctx, key)
+		cache.GetMultiWithExpiry([]string{key})
+	}
+
+	if s := cache.Stats(); s.Hits != 8 || s.Misses != 8 {
+		t.Errorf("Stats() hits, misses = %v, %v, want %v, %v", s.Hits, s.Misses, 8, 8)
+	}
+}
+
+func TestCacheRecentHitRatio(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := New(10, WithClock(func() time.Time { return now }), WithHitRatioWindow(1*time.Minute))
+	if err := cache.Set(testKey, testValue, 0); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	for range 3 {
+		_, _ = cache.Get(testKey)
+	}
+	now = now.Add(30 * time.Second)
+	_, _ = cache.Get("missing")
+
+	if got := cache.RecentHitRatio(10 * time.Second); got != 0 {
+		t.Errorf("RecentHitRatio() = %v, want %v", got, 0)
+	}
+	if got := cache.RecentHitRatio(1 * time.Minute); got != 0.75 {
+		t.Errorf("RecentHitRatio() = %v, want %v", got, 0.75)
+	}
+
+	// The hits have left the window, the lifetime ratio still includes them.
+	now = now.Add(45 * time.Second)
+	if got := cache.RecentHitRatio(1 * time.Hour); got != 0 {
+		t.Errorf("RecentHitRatio() = %v, want %v", got, 0)
+	}
+	if got := cache.Stats().HitRatio(); got != 0.75 {
+		t.Errorf("HitRatio() = %v, want %v", got, 0.75)
+	}
+
+	if got := New(10).RecentHitRatio(1 * time.Minute); got != 0 {
+		t.Errorf("RecentHitRatio() = %v, want %v", got, 0)
+	}
+}
@@ -0,0 +1,118 @@
+package scache
+
+import (
+	"cmp"
+	"container/heap"
+	"slices"
+)
+
+// KeyCount is a key together with its approximate number of hits, see TopKeys.
+type KeyCount struct {
+	Key   string
+	Count uint64
+}
+
+// sketchDepth is the number of rows of the count-min sketch used by WithTopK.
+const sketchDepth = 4
+
+// topK tracks the approximately most frequently hit keys in bounded memory. Hit counts are
+// estimated with a count-min sketch, which may overestimate but never underestimates, and
+// only the k keys with the highest estimates are remembered, in a min-heap.
+type topK struct {
+	k      int
+	width  uint64
+	sketch [sketchDepth][]uint64
+	keys   keyCountHeap // The remembered keys
+}
+
+// newTopK returns a topK remembering k keys.
+func newTopK(k int) *topK {
+	t := &topK{
+		k:     k,
+		width: uint64(max(1024, 64*k)),
+		keys:  keyCountHeap{index: make(map[string]int, k)},
+	}
+	for i := range t.sketch {
+		t.sketch[i] = make([]uint64, t.width)
+	}
+	return t
+}
+
+// record counts a hit of key.
+func (t *topK) record(key string) {
+	// Derive the row hashes from two halves of a single hash (double hashing).
+	h := fnv1a(key)
+	h1, h2 := h&0xffffffff, h>>32|1
+	count := uint64(0)
+	for i := range t.sketch {
+		cell := &t.sketch[i][(h1+uint64(i)*h2)%t.width]
+		if *cell < ^uint64(0) {
+			*cell++
+		}
+		if i == 0 || *cell < count {
+			count = *cell
+		}
+	}
+
+	switch i, found := t.keys.index[key]; {
+	case found:
+		t.keys.items[i].Count = count
+		heap.Fix(&t.keys, i)
+	case len(t.keys.items) < t.k:
+		heap.Push(&t.keys, KeyCount{Key: key, Count: count})
+	case count > t.keys.items[0].Count:
+		delete(t.keys.index, t.keys.items[0].Key)
+		t.keys.items[0] = KeyCount{Key: key, Count: count}
+		t.keys.index[key] = 0
+		heap.Fix(&t.keys, 0)
+	}
+}
+
+// top returns the remembered keys, most frequently hit first.
+func (t *topK) top() []KeyCount {
+	keys := slices.Clone(t.keys.items)
+	slices.SortFunc(keys, func(a, b KeyCount) int {
+		return cmp.Compare(b.Count, a.Count)
+	})
+	return keys
+}
+
+// keyCountHeap is a min-heap of key counts that keeps index up to date.
+type keyCountHeap struct {
+	items []KeyCount
+	index map[string]int // Position of each key in items
+}
+
+func (h *keyCountHeap) Len() int           { return len(h.items) }
+func (h *keyCountHeap) Less(i, j int) bool { return h.items[i].Count < h.items[j].Count }
+
+func (h *keyCountHeap) Swap(i, j int) {
+	h.items[i], h.items[j] = h.items[j], h.items[i]
+	h.index[h.items[i].Key] = i
+	h.index[h.items[j].Key] = j
+}
+
+func (h *keyCountHeap) Push(x any) {
+	kc := x.(KeyCount)
+	h.index[kc.Key] = len(h.items)
+	h.items = append(h.items, kc)
+}
+
+func (h *keyCountHeap) Pop() any {
+	kc := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	delete(h.index, kc.Key)
+	return kc
+}
+
+// TopKeys returns the keys with the most hits, as configured with WithTopK, most frequently
+// hit first. The counts are estimates that may be slightly too high. It returns nil if
+// WithTopK is not set.
+func (c *Cache) TopKeys() []KeyCount {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.topK == nil {
+		return nil
+	}
+	return c.topK.top()
+}
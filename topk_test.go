@@ -0,0 +1,41 @@
+package scache
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCacheTopKeys(t *testing.T) {
+	cache := New(1000, WithTopK(3))
+	for i := 0; i < 500; i++ {
+		if err := cache.Set(strconv.Itoa(i), testValue, 1*time.Hour); err != nil {
+			t.Errorf("Set() = %v, want %v", err, nil)
+		}
+	}
+	hits := map[string]int{"7": 100, "42": 50, "99": 30}
+	for key, n := range hits {
+		for i := 0; i < n; i++ {
+			_, _ = cache.Get(key)
+		}
+	}
+	for i := 0; i < 500; i++ {
+		_, _ = cache.Get(strconv.Itoa(i))
+	}
+
+	top := cache.TopKeys()
+	want := []string{"7", "42", "99"}
+	if len(top) != len(want) {
+		t.Fatalf("TopKeys() = %v, want keys %v", top, want)
+	}
+	for i, key := range want {
+		if top[i].Key != key || top[i].Count < uint64(hits[key]+1) {
+			t.Errorf("TopKeys() = %v, want keys %v", top, want)
+			break
+		}
+	}
+
+	if top := New(10).TopKeys(); top != nil {
+		t.Errorf("TopKeys() = %v, want %v", top, nil)
+	}
+}
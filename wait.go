@@ -0,0 +1,64 @@
+package scache
+
+import (
+	"context"
+	"slices"
+)
+
+// GetOrWait returns the value stored for key. If the key is missing or expired, it blocks
+// until the key is stored, e.g. by Set or Merge, ctx is done or the cache is closed, returning
+// ctx.Err() or ErrClosed in the latter cases. A hit promotes the entry like Get. Waiters are
+// removed when their context is done, so abandoned calls do not leak.
+func (c *Cache) GetOrWait(ctx context.Context, key string) (string, error) {
+	key = c.normalize(key)
+	for {
+		c.lock()
+		if c.closed.Load() {
+			c.unlock()
+			return "", ErrClosed
+		}
+		if value, err := c.getLocked(key, c.now()); err == nil {
+			c.unlock()
+			return value, nil
+		}
+		c.countLookup(false)
+		stored := make(chan struct{})
+		if c.waiters == nil {
+			c.waiters = make(map[string][]chan struct{})
+		}
+		c.waiters[key] = append(c.waiters[key], stored)
+		c.unlock()
+
+		select {
+		case <-stored:
+			// Look the key up again; it may already be gone.
+		case <-ctx.Done():
+			c.lock()
+			c.removeWaiter(key, stored)
+			c.unlock()
+			return "", ctx.Err()
+		case <-c.done:
+			return "", ErrClosed
+		}
+	}
+}
+
+// notifyWaiters wakes up every GetOrWait call waiting for key. The caller must hold the lock.
+func (c *Cache) notifyWaiters(key string) {
+	for _, stored := range c.waiters[key] {
+		close(stored)
+	}
+	delete(c.waiters, key)
+}
+
+// removeWaiter unregisters a GetOrWait call waiting for key. The caller must hold the lock.
+func (c *Cache) removeWaiter(key string, stored chan struct{}) {
+	waiters := slices.DeleteFunc(c.waiters[key], func(ch chan struct{}) bool {
+		return ch == stored
+	})
+	if len(waiters) == 0 {
+		delete(c.waiters, key)
+	} else {
+		c.waiters[key] = waiters
+	}
+}
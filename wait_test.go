@@ -0,0 +1,65 @@
+package scache
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCacheGetOrWait(t *testing.T) {
+	cache := New(10)
+	if err := cache.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	if value, err := cache.GetOrWait(context.Background(), testKey); err != nil || value != testValue {
+		t.Errorf("GetOrWait() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+
+	time.AfterFunc(5*time.Millisecond, func() {
+		_ = cache.Set("later", testValue, 1*time.Hour)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if value, err := cache.GetOrWait(ctx, "later"); err != nil || value != testValue {
+		t.Errorf("GetOrWait() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+	if len(cache.waiters) != 0 {
+		t.Errorf("len(waiters) = %v, want %v", len(cache.waiters), 0)
+	}
+}
+
+func TestCacheGetOrWaitMerge(t *testing.T) {
+	cache := New(10)
+	other := New(10)
+	if err := other.Set(testKey, testValue, 1*time.Hour); err != nil {
+		t.Errorf("Set() = %v, want %v", err, nil)
+	}
+	time.AfterFunc(5*time.Millisecond, func() {
+		cache.Merge(other, KeepExisting)
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	if value, err := cache.GetOrWait(ctx, testKey); err != nil || value != testValue {
+		t.Errorf("GetOrWait() = %v, %v, want %v, %v", value, err, testValue, nil)
+	}
+}
+
+func TestCacheGetOrWaitCancel(t *testing.T) {
+	cache := New(10)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := cache.GetOrWait(ctx, testKey); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetOrWait() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if len(cache.waiters) != 0 {
+		t.Errorf("len(waiters) = %v, want %v", len(cache.waiters), 0)
+	}
+
+	time.AfterFunc(5*time.Millisecond, func() {
+		_ = cache.Close()
+	})
+	if _, err := cache.GetOrWait(context.Background(), testKey); !errors.Is(err, ErrClosed) {
+		t.Errorf("GetOrWait() = %v, want %v", err, ErrClosed)
+	}
+}